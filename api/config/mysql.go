@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/ydv-ankit/go-url-shortener/models"
 	"github.com/ydv-ankit/go-url-shortener/utils"
@@ -14,15 +16,36 @@ var MySQLClient *gorm.DB
 
 func CreateMySQLClient() {
 	dsn := os.Getenv("MYSQL_USER") + ":" + os.Getenv("MYSQL_PASS") + "@tcp(" + os.Getenv("MYSQL_HOST") + ")/" + os.Getenv("MYSQL_DB") + "?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		panic(err)
 	}
 
 	// auto migrate models
-	db.AutoMigrate(&models.User{}, &models.Url{}, &models.UrlClick{})
+	db.AutoMigrate(&models.User{}, &models.Url{}, &models.UrlClick{}, &models.RecoveryCode{}, &models.Workspace{}, &models.WorkspaceMember{}, &models.WorkspaceInvitation{}, &models.AbuseReport{}, &models.AuditEvent{}, &models.MonthlyUsage{}, &models.ReservedWord{}, &models.Alias{}, &models.Domain{})
 	utils.Log("MYSQL client connected")
 
+	if err := models.MigrateUrlIndexes(db); err != nil {
+		utils.Log("error migrating url indexes: " + err.Error())
+	}
+
+	// short codes compare with or without case
+	if err := models.SetShortCaseInsensitive(db, utils.GetEnvBool("SHORTCODE_CASE_INSENSITIVE", false)); err != nil {
+		panic("error setting short code case mode, remove codes that only differ in case first: " + err.Error())
+	}
+
+	// links created before aliases get their primary alias
+	if err := models.BackfillAliases(db); err != nil {
+		utils.Log("error backfilling aliases: " + err.Error())
+	}
+
+	// bootstrap the first admins from env
+	if promoted, err := models.BootstrapAdmins(db, AdminEmails()); err != nil {
+		utils.Log("error promoting admins: " + err.Error())
+	} else if promoted > 0 {
+		utils.Log("promoted " + strconv.FormatInt(promoted, 10) + " users from ADMIN_EMAILS to admin")
+	}
+
 	MySQLClient = db
 }
 
@@ -32,3 +55,24 @@ func GetMySQLClient() *gorm.DB {
 	}
 	return MySQLClient
 }
+
+// IsAdminEmail reports whether email is listed in ADMIN_EMAILS, ignoring case
+func IsAdminEmail(email string) bool {
+	for _, admin := range AdminEmails() {
+		if strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminEmails returns the emails listed in ADMIN_EMAILS
+func AdminEmails() []string {
+	emails := []string{}
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
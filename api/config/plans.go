@@ -0,0 +1,117 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+const DEFAULT_PLAN = "free"
+
+// Plan caps what a user or workspace can create, a limit of 0 means unlimited
+type Plan struct {
+	Name             string `json:"name"`
+	MaxActiveLinks   int    `json:"maxActiveLinks"`
+	MaxCustomAliases int    `json:"maxCustomAliases"`
+	MaxLinksPerMonth int    `json:"maxLinksPerMonth"`
+	MaxExpiryDays    int    `json:"maxExpiryDays"`
+}
+
+var defaultPlans = map[string]Plan{
+	"free": {
+		MaxActiveLinks:   1000,
+		MaxCustomAliases: 50,
+		MaxLinksPerMonth: 500,
+		MaxExpiryDays:    365,
+	},
+	"pro": {
+		MaxActiveLinks:   100000,
+		MaxCustomAliases: 10000,
+		MaxLinksPerMonth: 50000,
+		MaxExpiryDays:    3650,
+	},
+	"unlimited": {},
+}
+
+var (
+	plans     map[string]Plan
+	plansErr  error
+	plansOnce sync.Once
+)
+
+// LoadPlans reads the plans once and reports configuration errors. PLANS_FILE
+// names a JSON object of plan names to limits that replaces the built-in
+// free, pro and unlimited plans. DEFAULT_PLAN picks the plan of users and
+// workspaces without one.
+func LoadPlans() error {
+	plansOnce.Do(func() {
+		plans, plansErr = loadPlans()
+	})
+	return plansErr
+}
+
+func loadPlans() (map[string]Plan, error) {
+	loaded := defaultPlans
+	if path := os.Getenv("PLANS_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		loaded = map[string]Plan{}
+		if err := json.Unmarshal(content, &loaded); err != nil {
+			return nil, fmt.Errorf("invalid PLANS_FILE: %w", err)
+		}
+	}
+	for name, plan := range loaded {
+		if plan.MaxActiveLinks < 0 || plan.MaxCustomAliases < 0 || plan.MaxLinksPerMonth < 0 || plan.MaxExpiryDays < 0 {
+			return nil, fmt.Errorf("plan %q has a negative limit", name)
+		}
+		plan.Name = name
+		loaded[name] = plan
+	}
+	if _, ok := loaded[defaultPlanName()]; !ok {
+		return nil, fmt.Errorf("default plan %q is not defined", defaultPlanName())
+	}
+	return loaded, nil
+}
+
+func defaultPlanName() string {
+	if name := os.Getenv("DEFAULT_PLAN"); name != "" {
+		return name
+	}
+	return DEFAULT_PLAN
+}
+
+func IsValidPlan(name string) bool {
+	if err := LoadPlans(); err != nil {
+		return false
+	}
+	_, ok := plans[name]
+	return ok
+}
+
+// GetPlan returns the named plan, the default plan for empty or unknown names
+func GetPlan(name string) Plan {
+	if err := LoadPlans(); err != nil {
+		return Plan{Name: name}
+	}
+	if plan, ok := plans[name]; ok {
+		return plan
+	}
+	return plans[defaultPlanName()]
+}
+
+// PlanNames returns the configured plan names sorted
+func PlanNames() []string {
+	names := []string{}
+	if err := LoadPlans(); err != nil {
+		return names
+	}
+	for name := range plans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,247 @@
+// Package domains checks that users control the custom domains they add, with
+// a DNS TXT record or a token served over HTTP. Lookups go through a Resolver,
+// so a stub can replace the network.
+package domains
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ydv-ankit/go-url-shortener/utils"
+	"golang.org/x/net/idna"
+)
+
+// verification methods
+const (
+	METHOD_DNS  = "dns"
+	METHOD_HTTP = "http"
+)
+
+const (
+	// TXT records are looked up on _ziplink.{host}
+	TXT_RECORD_PREFIX = "_ziplink."
+	TXT_VALUE_PREFIX  = "ziplink-verification="
+	WELL_KNOWN_PATH   = "/.well-known/ziplink-verification"
+	LOOKUP_TIMEOUT    = 10 * time.Second
+	// longest well-known response read
+	MAX_TOKEN_BODY = 1024
+	MAX_REDIRECTS  = 3
+)
+
+var (
+	ErrUnknownMethod   = errors.New("verification method must be dns or http")
+	ErrInvalidHost     = errors.New("domain must be a valid host name such as go.example.com")
+	ErrServiceHost     = errors.New("domain is the service's own domain")
+	ErrPrivateAddress  = errors.New("domain resolves to a private address")
+	ErrForeignRedirect = errors.New("redirect leaves the domain")
+)
+
+// carrier-grade nat, not covered by net.IP.IsPrivate
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Resolver looks up the proofs of ownership of a domain
+type Resolver interface {
+	// LookupTXT returns the TXT records of name
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	// FetchToken returns the body served at url
+	FetchToken(ctx context.Context, url string) (string, error)
+}
+
+var resolver Resolver = NewNetResolver()
+
+// SetResolver replaces the resolver used by Verify, e.g. with a StubResolver
+func SetResolver(r Resolver) {
+	resolver = r
+}
+
+// NetResolver looks up TXT records with the system resolver and fetches tokens
+// over HTTP. It only connects to public addresses and follows redirects on the
+// same host, so users cannot point it at internal services.
+type NetResolver struct {
+	client *http.Client
+}
+
+func NewNetResolver() NetResolver {
+	dialer := &net.Dialer{Timeout: LOOKUP_TIMEOUT, Control: publicAddressOnly}
+	return NetResolver{client: &http.Client{
+		Timeout: LOOKUP_TIMEOUT,
+		// no proxy, it would dial instead of us
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: LOOKUP_TIMEOUT,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= MAX_REDIRECTS {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Hostname() != via[0].URL.Hostname() {
+				return ErrForeignRedirect
+			}
+			return nil
+		},
+	}}
+}
+
+// publicAddressOnly refuses connections to loopback, private, link-local and
+// other non-public addresses. It runs after name resolution, so every address
+// a host resolves to is checked.
+func publicAddressOnly(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !IsPublicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// IsPublicIP reports whether ip is a globally routable unicast address
+func IsPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+func (NetResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return net.DefaultResolver.LookupTXT(ctx, name)
+}
+
+func (r NetResolver) FetchToken(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned status %d", url, res.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, MAX_TOKEN_BODY))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// StubResolver answers from maps instead of the network, keyed by TXT record
+// name and by url
+type StubResolver struct {
+	TXT    map[string][]string
+	Tokens map[string]string
+}
+
+func (s StubResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := s.TXT[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return records, nil
+}
+
+func (s StubResolver) FetchToken(ctx context.Context, url string) (string, error) {
+	token, ok := s.Tokens[url]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return token, nil
+}
+
+// NormalizeHost lowercases a domain and converts IDNs to punycode. Domains need
+// at least two labels and cannot be ip addresses or the service's own DOMAIN.
+func NormalizeHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.TrimSpace(host), ".")
+	if host == "" || net.ParseIP(host) != nil {
+		return "", ErrInvalidHost
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", ErrInvalidHost
+	}
+	ascii = strings.ToLower(ascii)
+	labels := strings.Split(ascii, ".")
+	if len(ascii) > 253 || len(labels) < 2 {
+		return "", ErrInvalidHost
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", ErrInvalidHost
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return "", ErrInvalidHost
+			}
+		}
+	}
+	if ascii == utils.ServiceHostname() {
+		return "", ErrServiceHost
+	}
+	return ascii, nil
+}
+
+// TXTRecordName is the record that proves ownership of host with METHOD_DNS
+func TXTRecordName(host string) string {
+	return TXT_RECORD_PREFIX + host
+}
+
+// TXTRecordValue is the value of the TXT record for token
+func TXTRecordValue(token string) string {
+	return TXT_VALUE_PREFIX + token
+}
+
+// WellKnownUrl serves the token of host with METHOD_HTTP, over https or http
+func WellKnownUrl(host string) string {
+	return "https://" + host + WELL_KNOWN_PATH
+}
+
+// wellKnownUrls are tried in order, https first
+func wellKnownUrls(host string) []string {
+	return []string{WellKnownUrl(host), "http://" + host + WELL_KNOWN_PATH}
+}
+
+// Verify checks the proof of ownership of host for the method
+func Verify(ctx context.Context, method string, host string, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, LOOKUP_TIMEOUT)
+	defer cancel()
+	switch method {
+	case METHOD_DNS:
+		records, err := resolver.LookupTXT(ctx, TXTRecordName(host))
+		if err != nil {
+			return fmt.Errorf("no TXT record found at %s", TXTRecordName(host))
+		}
+		for _, record := range records {
+			if strings.TrimSpace(record) == TXTRecordValue(token) {
+				return nil
+			}
+		}
+		return fmt.Errorf("TXT record at %s does not contain %s", TXTRecordName(host), TXTRecordValue(token))
+	case METHOD_HTTP:
+		var verifyErr error
+		for _, url := range wellKnownUrls(host) {
+			body, err := resolver.FetchToken(ctx, url)
+			if errors.Is(err, ErrPrivateAddress) || errors.Is(err, ErrForeignRedirect) {
+				// the other scheme reaches the same addresses
+				return fmt.Errorf("could not fetch %s: %w", url, err)
+			}
+			if err != nil {
+				verifyErr = fmt.Errorf("could not fetch %s", url)
+				continue
+			}
+			if strings.TrimSpace(body) == token {
+				return nil
+			}
+			verifyErr = fmt.Errorf("%s does not serve the verification token", url)
+		}
+		return verifyErr
+	}
+	return ErrUnknownMethod
+}
@@ -0,0 +1,158 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	testHost  = "go.example.com"
+	testToken = "3f9c0a"
+)
+
+func useStub(t *testing.T, stub StubResolver) {
+	t.Helper()
+	SetResolver(stub)
+	t.Cleanup(func() { SetResolver(NewNetResolver()) })
+}
+
+func TestNormalizeHost(t *testing.T) {
+	t.Setenv("DOMAIN", "zip.link:8080")
+	tests := []struct {
+		host string
+		want string
+		err  error
+	}{
+		{"go.example.com", "go.example.com", nil},
+		{" Go.Example.COM. ", "go.example.com", nil},
+		{"bücher.de", "xn--bcher-kva.de", nil},
+		{"", "", ErrInvalidHost},
+		{"localhost", "", ErrInvalidHost},
+		{"10.0.0.1", "", ErrInvalidHost},
+		{"::1", "", ErrInvalidHost},
+		{"-go.example.com", "", ErrInvalidHost},
+		{"go..example.com", "", ErrInvalidHost},
+		{"go_links.example.com", "", ErrInvalidHost},
+		{"go.example.com/path", "", ErrInvalidHost},
+		{"ZIP.link", "", ErrServiceHost},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeHost(tt.host)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("NormalizeHost(%q) = %q, %v, want %q, %v", tt.host, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestVerifyDNS(t *testing.T) {
+	tests := []struct {
+		name string
+		txt  map[string][]string
+		ok   bool
+	}{
+		{"matching record", map[string][]string{TXTRecordName(testHost): {"v=spf1 -all", TXTRecordValue(testToken)}}, true},
+		{"record with spaces", map[string][]string{TXTRecordName(testHost): {" " + TXTRecordValue(testToken) + " "}}, true},
+		{"other token", map[string][]string{TXTRecordName(testHost): {TXTRecordValue("other")}}, false},
+		{"record on the host itself", map[string][]string{testHost: {TXTRecordValue(testToken)}}, false},
+		{"no record", map[string][]string{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStub(t, StubResolver{TXT: tt.txt})
+			err := Verify(context.Background(), METHOD_DNS, testHost, testToken)
+			if (err == nil) != tt.ok {
+				t.Errorf("Verify() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestVerifyHTTP(t *testing.T) {
+	httpsUrl := "https://" + testHost + WELL_KNOWN_PATH
+	httpUrl := "http://" + testHost + WELL_KNOWN_PATH
+	tests := []struct {
+		name   string
+		tokens map[string]string
+		ok     bool
+	}{
+		{"https", map[string]string{httpsUrl: testToken + "\n"}, true},
+		{"http fallback", map[string]string{httpUrl: testToken}, true},
+		{"wrong https token, right http token", map[string]string{httpsUrl: "other", httpUrl: testToken}, true},
+		{"wrong token", map[string]string{httpsUrl: "other", httpUrl: "other"}, false},
+		{"not served", map[string]string{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStub(t, StubResolver{Tokens: tt.tokens})
+			err := Verify(context.Background(), METHOD_HTTP, testHost, testToken)
+			if (err == nil) != tt.ok {
+				t.Errorf("Verify() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestVerifyUnknownMethod(t *testing.T) {
+	useStub(t, StubResolver{TXT: map[string][]string{TXTRecordName(testHost): {TXTRecordValue(testToken)}}})
+	if err := Verify(context.Background(), "email", testHost, testToken); !errors.Is(err, ErrUnknownMethod) {
+		t.Errorf("Verify() = %v, want %v", err, ErrUnknownMethod)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::248": true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"::1":                  false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"224.0.0.1":            false,
+	}
+	for ip, want := range tests {
+		if got := IsPublicIP(net.ParseIP(ip)); got != want {
+			t.Errorf("IsPublicIP(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestNetResolverRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testToken))
+	}))
+	defer server.Close()
+	_, err := NewNetResolver().FetchToken(context.Background(), server.URL+WELL_KNOWN_PATH)
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("FetchToken() = %v, want %v", err, ErrPrivateAddress)
+	}
+}
+
+func TestNetResolverRedirects(t *testing.T) {
+	check := NewNetResolver().client.CheckRedirect
+	first, _ := http.NewRequest(http.MethodGet, "http://"+testHost+WELL_KNOWN_PATH, nil)
+	tests := []struct {
+		url string
+		err error
+	}{
+		{"https://" + testHost + WELL_KNOWN_PATH, nil},
+		{"http://169.254.169.254/latest/meta-data/", ErrForeignRedirect},
+		{"https://evil.example.net" + WELL_KNOWN_PATH, ErrForeignRedirect},
+	}
+	for _, tt := range tests {
+		next, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err := check(next, []*http.Request{first}); !errors.Is(err, tt.err) {
+			t.Errorf("CheckRedirect(%s) = %v, want %v", tt.url, err, tt.err)
+		}
+	}
+}
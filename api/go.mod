@@ -4,11 +4,14 @@ go 1.24.4
 
 require (
 	github.com/gofiber/fiber/v2 v2.52.10
+	github.com/pquerna/otp v1.5.0
+	golang.org/x/net v0.47.0
 	gorm.io/gorm v1.31.1
 )
 
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/go-sql-driver/mysql v1.8.1 // indirect
@@ -2,17 +2,30 @@ package main
 
 import (
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/monitor"
 	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/middleware"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/policy"
 	"github.com/ydv-ankit/go-url-shortener/routes"
+	"github.com/ydv-ankit/go-url-shortener/shortcode"
 	"github.com/ydv-ankit/go-url-shortener/utils"
 )
 
+// authMiddleware accepts the session cookie or the same token sent as an
+// Authorization bearer header
 func authMiddleware(c *fiber.Ctx) error {
 	token := c.Cookies("token")
+	authMethod := middleware.AUTH_METHOD_COOKIE
+	if bearer, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); ok {
+		token = strings.TrimSpace(bearer)
+		authMethod = middleware.AUTH_METHOD_BEARER
+	}
 	if token == "" {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"message": "Unauthorized",
@@ -20,7 +33,7 @@ func authMiddleware(c *fiber.Ctx) error {
 			"error":   "Unauthorized",
 		})
 	}
-	userId, err := utils.VerifyToken(token)
+	userId, version, err := utils.VerifyToken(token)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"message": "Unauthorized",
@@ -28,35 +41,133 @@ func authMiddleware(c *fiber.Ctx) error {
 			"error":   "Unauthorized",
 		})
 	}
+	// disabled accounts and revoked sessions are rejected immediately
+	user := &models.User{Id: userId}
+	if err := user.GetUserById(config.GetMySQLClient()); err != nil || user.Disabled || user.TokenVersion != version {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Unauthorized",
+			"success": false,
+			"error":   "Unauthorized",
+		})
+	}
 	c.Locals("userId", userId)
+	c.Locals("role", user.Role)
+	c.Locals("authMethod", authMethod)
+	return c.Next()
+}
+
+func adminMiddleware(c *fiber.Ctx) error {
+	if c.Locals("role") != models.ROLE_ADMIN {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"success": false,
+			"error":   "Admin access required",
+		})
+	}
 	return c.Next()
 }
 
 func setupRoutes(app *fiber.App) {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     os.Getenv("APP_URL_FRONTEND"),
-		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Workspace-Id, X-CSRF-Token, Idempotency-Key",
 		AllowMethods:     "GET, POST, PUT, DELETE, OPTIONS",
+		ExposeHeaders:    "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Retry-After, Idempotent-Replayed",
 		AllowCredentials: true,
 	}))
 	// metrics route
 	app.Get("/metrics", monitor.New())
+	// public keys for verifying our tokens
+	app.Get("/.well-known/jwks.json", routes.GetJWKS)
 
 	// user routes
 	app.Post("/api/v1/create-user", routes.CreateUser)
 	app.Post("/api/v1/login", routes.LoginUser)
+	app.Post("/api/v1/login/2fa", routes.LoginTwoFactor)
 	app.Post("/api/v1/logout", routes.LogoutUser)
 
 	// url routes
-	app.Get("/:short", routes.ResolveUrl)
+	redirectLimiter := middleware.RateLimitFromEnv("redirect", "RATE_LIMIT_REDIRECT", 120, time.Minute, middleware.KeyByIP)
+	app.Get("/:short", redirectLimiter, routes.ResolveUrl)
+	// namespaced links, /u/{handle}/{slug} never matches the single segment route above
+	app.Get("/"+routes.NamespacePrefix()+"/:handle/:slug", redirectLimiter, routes.ResolveNamespacedUrl)
+	// abuse reports
+	reportLimiter := middleware.RateLimitFromEnv("report", "RATE_LIMIT_REPORT", 10, time.Hour, middleware.KeyByIP)
+	app.Post("/api/v1/report", reportLimiter, routes.ReportUrl)
 	// auth middleware
 	app.Use(authMiddleware)
+	// cookie authenticated mutations need the csrf token
+	app.Use(middleware.CSRFProtection)
+	app.Get("/api/v1/csrf", routes.GetCSRFToken)
 	// get all urls by user id route
 	app.Get("/api/v1/urls", routes.GetAllUrlsByUserId)
 	// shorten url route
-	app.Post("/api/v1/shorten", routes.ShortenUrl)
+	shortenLimiter := middleware.RateLimitFromEnv("shorten", "RATE_LIMIT_SHORTEN", 30, time.Minute, middleware.KeyByIdentity)
+	// retries with the same Idempotency-Key get the first response back
+	app.Post("/api/v1/shorten", shortenLimiter, middleware.Idempotency(), routes.ShortenUrl)
+	// custom alias availability and suggestions, checked as the user types
+	availabilityLimiter := middleware.RateLimitFromEnv("availability", "RATE_LIMIT_AVAILABILITY", 60, time.Minute, middleware.KeyByIdentity)
+	app.Get("/api/v1/shorten/availability", availabilityLimiter, routes.CheckAliasAvailability)
+	// plan usage route
+	app.Get("/api/v1/usage", routes.GetUsage)
 	// delete url route
 	app.Delete("/api/v1/delete", routes.DeleteUrl)
+	// aliases of a url and its clicks per alias
+	app.Get("/api/v1/urls/:id/aliases", routes.GetUrlAliases)
+	app.Post("/api/v1/urls/:id/aliases", shortenLimiter, routes.AddUrlAlias)
+	app.Delete("/api/v1/urls/:id/aliases/:aliasId", routes.DeleteUrlAlias)
+	app.Put("/api/v1/urls/:id/aliases/:aliasId/primary", routes.SetPrimaryUrlAlias)
+	app.Get("/api/v1/urls/:id/stats", routes.GetUrlStats)
+	// account routes
+	app.Get("/api/v1/me", routes.GetProfile)
+	app.Put("/api/v1/me", routes.UpdateProfile)
+	app.Put("/api/v1/me/password", routes.ChangePassword)
+	app.Delete("/api/v1/me", routes.DeleteAccount)
+	// privacy routes
+	app.Get("/api/v1/me/export", routes.ExportAccountData)
+	app.Post("/api/v1/me/erase", routes.EraseAccount)
+	// two-factor authentication routes
+	app.Post("/api/v1/2fa/enroll", routes.EnrollTwoFactor)
+	app.Post("/api/v1/2fa/verify", routes.VerifyTwoFactor)
+	app.Post("/api/v1/2fa/disable", routes.DisableTwoFactor)
+
+	// audit log, admins see every event
+	app.Get("/api/v1/audit", routes.GetAuditEvents)
+
+	// workspace routes
+	app.Post("/api/v1/workspaces", routes.CreateWorkspace)
+	app.Get("/api/v1/workspaces", routes.GetWorkspaces)
+	app.Post("/api/v1/workspaces/invitations/accept", routes.AcceptWorkspaceInvitation)
+	app.Get("/api/v1/workspaces/:id/members", routes.GetWorkspaceMembers)
+	app.Post("/api/v1/workspaces/:id/invitations", routes.InviteWorkspaceMember)
+	app.Put("/api/v1/workspaces/:id/members/:userId", routes.UpdateWorkspaceMember)
+	app.Delete("/api/v1/workspaces/:id/members/:userId", routes.RemoveWorkspaceMember)
+
+	// custom domain routes, verification looks up dns and fetches the token
+	verifyDomainLimiter := middleware.RateLimitFromEnv("domain_verify", "RATE_LIMIT_DOMAIN_VERIFY", 10, time.Minute, middleware.KeyByIdentity)
+	app.Get("/api/v1/domains", routes.GetDomains)
+	app.Post("/api/v1/domains", routes.AddDomain)
+	app.Post("/api/v1/domains/:id/verify", verifyDomainLimiter, routes.VerifyDomain)
+	app.Delete("/api/v1/domains/:id", routes.DeleteDomain)
+
+	// admin routes
+	admin := app.Group("/api/v1/admin", adminMiddleware)
+	admin.Get("/users", routes.AdminListUsers)
+	admin.Put("/users/:id/status", routes.AdminSetUserStatus)
+	admin.Put("/users/:id/role", routes.AdminSetUserRole)
+	admin.Put("/users/:id/plan", routes.AdminSetUserPlan)
+	admin.Put("/workspaces/:id/plan", routes.AdminSetWorkspacePlan)
+	admin.Get("/urls", routes.AdminListUrls)
+	admin.Post("/urls/:short/takedown", routes.AdminTakedownUrl)
+	admin.Post("/urls/:short/restore", routes.AdminRestoreUrl)
+	admin.Get("/reports", routes.AdminListReports)
+	admin.Get("/reports/:short", routes.AdminGetUrlReports)
+	admin.Post("/reports/:short/takedown", routes.AdminTakedownReportedUrl)
+	admin.Post("/reports/:short/dismiss", routes.AdminDismissReports)
+	admin.Post("/reports/:short/ban-owner", routes.AdminBanReportedOwner)
+	admin.Get("/reserved-words", routes.AdminListReservedWords)
+	admin.Post("/reserved-words", routes.AdminAddReservedWord)
+	admin.Delete("/reserved-words/:id", routes.AdminDeleteReservedWord)
 }
 
 func main() {
@@ -65,11 +176,41 @@ func main() {
 	// parse env file
 	utils.EnvParser()
 
+	// load jwt signing keys
+	if err := utils.LoadJWTKeys(); err != nil {
+		panic("Failed to load jwt keys: " + err.Error())
+	}
+
+	// validate ip privacy settings
+	if err := utils.CheckIPPrivacyConfig(); err != nil {
+		panic("Invalid ip privacy config: " + err.Error())
+	}
+
+	// select the short code generator
+	if err := shortcode.Configure(); err != nil {
+		panic("Invalid short code config: " + err.Error())
+	}
+
+	// load plans
+	if err := config.LoadPlans(); err != nil {
+		panic("Failed to load plans: " + err.Error())
+	}
+
+	// load destination blocklists
+	policy.Start()
+
 	// connect to db
 	config.CreateMySQLClient()
 
+	// purge click data past its retention period
+	routes.StartRetentionPurge()
+	// keep a pool of unused short codes in redis
+	routes.StartKeyPool()
+
 	// setup routes
 	setupRoutes(app)
+	// short codes and handles can never take the path of a route
+	routes.ReserveRoutes(app)
 
 	// start server
 	err := app.Listen(os.Getenv("APP_PORT"))
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+)
+
+const (
+	IDEMPOTENCY_HEADER          = "Idempotency-Key"
+	IDEMPOTENCY_REPLAYED_HEADER = "Idempotent-Replayed"
+	IDEMPOTENCY_TTL_HOURS       = 24
+	MAX_IDEMPOTENCY_KEY_LENGTH  = 255
+	// how long a key stays claimed by a request that has not finished
+	IDEMPOTENCY_LOCK_TTL = time.Minute
+)
+
+// states of a stored key
+const (
+	IDEMPOTENCY_PENDING = "pending"
+	IDEMPOTENCY_DONE    = "done"
+)
+
+// idempotentResponse is stored per key, pending while the first request runs
+type idempotentResponse struct {
+	State       string `json:"state"`
+	RequestHash string `json:"requestHash"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        string `json:"body,omitempty"`
+}
+
+// requestHash identifies the request a key was first used with
+func requestHash(c *fiber.Ctx) string {
+	sum := sha256.New()
+	sum.Write([]byte(c.Method() + " " + c.Path() + "\n" + c.Get("X-Workspace-Id") + "\n"))
+	sum.Write(c.Body())
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// Idempotency replays the stored response of a request retried with the same
+// Idempotency-Key header, for IDEMPOTENCY_TTL_HOURS. Keys are scoped to the
+// identity of the caller, server errors are not stored so they can be retried.
+// It fails open when redis is unavailable.
+func Idempotency() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IDEMPOTENCY_HEADER)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > MAX_IDEMPOTENCY_KEY_LENGTH {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid idempotency key",
+				"success": false,
+				"error":   "Idempotency-Key must be at most 255 characters",
+			})
+		}
+		keySum := sha256.Sum256([]byte(key))
+		redisKey := "idempotency:" + KeyByIdentity(c) + ":" + hex.EncodeToString(keySum[:16])
+		hash := requestHash(c)
+		client := config.GetRedisClient(0)
+
+		pending, _ := json.Marshal(idempotentResponse{State: IDEMPOTENCY_PENDING, RequestHash: hash})
+		claimed, err := client.SetNX(config.RedisCtx, redisKey, pending, IDEMPOTENCY_LOCK_TTL).Result()
+		if err != nil {
+			utils.Log("idempotency store unavailable: " + err.Error())
+			return c.Next()
+		}
+		if !claimed {
+			return replayResponse(c, redisKey, hash)
+		}
+
+		if err := c.Next(); err != nil {
+			client.Del(config.RedisCtx, redisKey)
+			return err
+		}
+		status := c.Response().StatusCode()
+		if status >= fiber.StatusInternalServerError {
+			client.Del(config.RedisCtx, redisKey)
+			return nil
+		}
+		stored, err := json.Marshal(idempotentResponse{
+			State:       IDEMPOTENCY_DONE,
+			RequestHash: hash,
+			Status:      status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Body:        string(c.Response().Body()),
+		})
+		if err == nil {
+			ttl := time.Hour * time.Duration(utils.GetEnvInt("IDEMPOTENCY_TTL_HOURS", IDEMPOTENCY_TTL_HOURS))
+			err = client.Set(config.RedisCtx, redisKey, stored, ttl).Err()
+		}
+		if err != nil {
+			utils.Log("error storing idempotent response: " + err.Error())
+		}
+		return nil
+	}
+}
+
+// replayResponse answers a request whose key is already in use
+func replayResponse(c *fiber.Ctx, redisKey string, hash string) error {
+	raw, err := config.GetRedisClient(0).Get(config.RedisCtx, redisKey).Result()
+	if err == redis.Nil {
+		// the first request just failed or expired, the client can retry
+		return idempotencyConflict(c, "The first request with this Idempotency-Key did not complete, retry")
+	}
+	stored := new(idempotentResponse)
+	if err == nil {
+		err = json.Unmarshal([]byte(raw), stored)
+	}
+	if err != nil {
+		utils.Log("idempotency store unavailable: " + err.Error())
+		return c.Next()
+	}
+	if stored.RequestHash != hash {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"message": "Idempotency key reused",
+			"success": false,
+			"error":   "Idempotency-Key was already used with a different request",
+		})
+	}
+	if stored.State == IDEMPOTENCY_PENDING {
+		return idempotencyConflict(c, "A request with this Idempotency-Key is in progress, retry shortly")
+	}
+	c.Set(IDEMPOTENCY_REPLAYED_HEADER, "true")
+	c.Set(fiber.HeaderContentType, stored.ContentType)
+	return c.Status(stored.Status).SendString(stored.Body)
+}
+
+func idempotencyConflict(c *fiber.Ctx, message string) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"message": "Request in progress",
+		"success": false,
+		"error":   message,
+	})
+}
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+)
+
+// KeyFunc returns the identity a request is counted against
+type KeyFunc func(c *fiber.Ctx) string
+
+type RateLimitConfig struct {
+	// Name of the route group, requests of different groups are counted separately
+	Name   string
+	Limit  int
+	Window time.Duration
+	KeyBy  KeyFunc
+}
+
+// slidingWindowScript keeps a sorted set of request timestamps per key, drops
+// the ones outside the window and admits the request if there is room left.
+// Returns {allowed, remaining, reset in ms}.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+var (
+	allowlist     []*net.IPNet
+	allowlistOnce sync.Once
+)
+
+// loadAllowlist parses RATE_LIMIT_ALLOWLIST, a comma separated list of ips and cidrs
+func loadAllowlist() {
+	for _, entry := range strings.Split(os.Getenv("RATE_LIMIT_ALLOWLIST"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			utils.Log("invalid RATE_LIMIT_ALLOWLIST entry: " + entry)
+			continue
+		}
+		allowlist = append(allowlist, network)
+	}
+}
+
+func isAllowlisted(ip string) bool {
+	allowlistOnce.Do(loadAllowlist)
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range allowlist {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyByIP counts requests per client ip
+func KeyByIP(c *fiber.Ctx) string {
+	return "ip:" + c.IP()
+}
+
+// KeyByIdentity counts requests per authenticated user, then per API key sent
+// as a bearer token, falling back to the client ip
+func KeyByIdentity(c *fiber.Ctx) string {
+	if userId, ok := c.Locals("userId").(string); ok && userId != "" {
+		return "user:" + userId
+	}
+	if apiKey, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); ok && apiKey != "" {
+		sum := sha256.Sum256([]byte(apiKey))
+		return "key:" + hex.EncodeToString(sum[:16])
+	}
+	return KeyByIP(c)
+}
+
+// RateLimit limits requests with a sliding window shared through redis. It
+// fails open when redis is unavailable.
+func RateLimit(cfg RateLimitConfig) fiber.Handler {
+	if cfg.KeyBy == nil {
+		cfg.KeyBy = KeyByIdentity
+	}
+	return func(c *fiber.Ctx) error {
+		if cfg.Limit <= 0 || isAllowlisted(c.IP()) {
+			return c.Next()
+		}
+		key := "ratelimit:" + cfg.Name + ":" + cfg.KeyBy(c)
+		now := time.Now().UnixMilli()
+		result, err := slidingWindowScript.Run(config.RedisCtx, config.GetRedisClient(0), []string{key},
+			now, cfg.Window.Milliseconds(), cfg.Limit, strconv.FormatInt(now, 10)+"-"+uuid.New().String()).Int64Slice()
+		if err != nil || len(result) != 3 {
+			utils.Log(fmt.Sprintf("rate limiter unavailable: %v", err))
+			return c.Next()
+		}
+		allowed, remaining, resetMs := result[0] == 1, result[1], result[2]
+		resetSeconds := (resetMs + 999) / 1000
+		c.Set("RateLimit-Limit", strconv.Itoa(cfg.Limit))
+		c.Set("RateLimit-Remaining", strconv.FormatInt(max(remaining, 0), 10))
+		c.Set("RateLimit-Reset", strconv.FormatInt(resetSeconds, 10))
+		c.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", cfg.Limit, int(cfg.Window.Seconds())))
+		if !allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(resetSeconds, 10))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"message": "Too many requests",
+				"success": false,
+				"error":   "Rate limit exceeded, try again later",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// RateLimitFromEnv builds a limiter for a route group configured with an env
+// variable of the form "limit/window", e.g. RATE_LIMIT_SHORTEN=30/1m. A limit
+// of 0 disables the limiter.
+func RateLimitFromEnv(name string, envKey string, limit int, window time.Duration, keyBy KeyFunc) fiber.Handler {
+	if value := os.Getenv(envKey); value != "" {
+		parsedLimit, parsedWindow, err := parseRateLimit(value)
+		if err != nil {
+			utils.Log("invalid " + envKey + ": " + err.Error())
+		} else {
+			limit, window = parsedLimit, parsedWindow
+		}
+	}
+	return RateLimit(RateLimitConfig{Name: name, Limit: limit, Window: window, KeyBy: keyBy})
+}
+
+func parseRateLimit(value string) (int, time.Duration, error) {
+	limitPart, windowPart, ok := strings.Cut(value, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected limit/window, got %q", value)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(limitPart))
+	if err != nil {
+		return 0, 0, err
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(windowPart))
+	if err != nil {
+		return 0, 0, err
+	}
+	if window <= 0 {
+		return 0, 0, fmt.Errorf("window must be positive")
+	}
+	return limit, window, nil
+}
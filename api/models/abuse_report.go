@@ -0,0 +1,138 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	REPORT_CATEGORY_PHISHING = "phishing"
+	REPORT_CATEGORY_MALWARE  = "malware"
+	REPORT_CATEGORY_SPAM     = "spam"
+	REPORT_CATEGORY_OTHER    = "other"
+)
+
+const (
+	REPORT_STATUS_OPEN      = "open"
+	REPORT_STATUS_DISMISSED = "dismissed"
+	REPORT_STATUS_RESOLVED  = "resolved"
+)
+
+const REPORT_REASON_MAX_LENGTH = 1000
+
+// prefix of the reporter ip of purged reports
+const REPORT_PURGED_IP_PREFIX = "purged:"
+
+// AbuseReport is a report of a short link, each reporter ip can report a link once
+type AbuseReport struct {
+	gorm.Model
+	Id         string `json:"id"`
+	UrlId      string `json:"urlId" gorm:"size:36;uniqueIndex:idx_report_url_reporter"`
+	ReporterIp string `json:"-" gorm:"size:45;uniqueIndex:idx_report_url_reporter"`
+	Category   string `json:"category"`
+	Reason     string `json:"reason" gorm:"size:1000"`
+	Status     string `json:"status" gorm:"default:open;index"`
+}
+
+func (AbuseReport) TableName() string {
+	return "abuse_reports"
+}
+
+func IsValidReportCategory(category string) bool {
+	switch category {
+	case REPORT_CATEGORY_PHISHING, REPORT_CATEGORY_MALWARE, REPORT_CATEGORY_SPAM, REPORT_CATEGORY_OTHER:
+		return true
+	}
+	return false
+}
+
+// CreateReport stores the report unless the reporter already reported the
+// link, returns whether a new report was created
+func (report *AbuseReport) CreateReport(tx *gorm.DB) (bool, error) {
+	if report.Id == "" {
+		report.Id = uuid.New().String()
+	}
+	if report.UrlId == "" {
+		return false, errors.New("urlId is required")
+	}
+	if !IsValidReportCategory(report.Category) {
+		return false, errors.New("invalid report category")
+	}
+	if len(report.Reason) > REPORT_REASON_MAX_LENGTH {
+		return false, errors.New("reason is too long")
+	}
+	if report.Status == "" {
+		report.Status = REPORT_STATUS_OPEN
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(report)
+	return result.RowsAffected == 1, result.Error
+}
+
+func CountOpenReportsByUrlId(tx *gorm.DB, urlId string) (int64, error) {
+	var count int64
+	err := tx.Model(&AbuseReport{}).Where("url_id = ? AND status = ?", urlId, REPORT_STATUS_OPEN).Count(&count).Error
+	return count, err
+}
+
+func GetReportsByUrlId(tx *gorm.DB, urlId string) ([]AbuseReport, error) {
+	reports := []AbuseReport{}
+	err := tx.Where("url_id = ?", urlId).Order("created_at DESC").Find(&reports).Error
+	return reports, err
+}
+
+// CloseOpenReports moves the open reports of a url to status
+func CloseOpenReports(tx *gorm.DB, urlId string, status string) error {
+	return tx.Model(&AbuseReport{}).Where("url_id = ? AND status = ?", urlId, REPORT_STATUS_OPEN).Update("status", status).Error
+}
+
+// AnonymizeClosedReportsBefore clears the reporter ip of resolved and dismissed
+// reports older than before. Open reports keep it to deduplicate reporters.
+// The ip is replaced with the report id so url and reporter stay unique.
+func AnonymizeClosedReportsBefore(tx *gorm.DB, before time.Time) (int64, error) {
+	result := tx.Model(&AbuseReport{}).
+		Where("created_at < ? AND status <> ? AND reporter_ip NOT LIKE ?", before, REPORT_STATUS_OPEN, REPORT_PURGED_IP_PREFIX+"%").
+		Update("reporter_ip", gorm.Expr("CONCAT(?, id)", REPORT_PURGED_IP_PREFIX))
+	return result.RowsAffected, result.Error
+}
+
+func DeleteReportsByUrlIds(tx *gorm.DB, urlIds []string) error {
+	if len(urlIds) == 0 {
+		return nil
+	}
+	return tx.Unscoped().Where("url_id IN ?", urlIds).Delete(&AbuseReport{}).Error
+}
+
+// ReportSummary aggregates the reports of one link
+type ReportSummary struct {
+	UrlId          string    `json:"urlId"`
+	Reports        int64     `json:"reports"`
+	LastReportedAt time.Time `json:"lastReportedAt"`
+}
+
+// ReportQueue returns links with reports in status, most reported first
+func ReportQueue(tx *gorm.DB, status string) *gorm.DB {
+	return tx.Model(&AbuseReport{}).
+		Select("url_id, COUNT(*) AS reports, MAX(created_at) AS last_reported_at").
+		Where("status = ?", status).
+		Group("url_id").
+		Order("reports DESC, last_reported_at DESC")
+}
+
+// CountReportsByCategory returns the number of reports in status per category for a url
+func CountReportsByCategory(tx *gorm.DB, urlId string, status string) (map[string]int64, error) {
+	rows := []struct {
+		Category string
+		Count    int64
+	}{}
+	err := tx.Model(&AbuseReport{}).Select("category, COUNT(*) AS count").
+		Where("url_id = ? AND status = ?", urlId, status).Group("category").Scan(&rows).Error
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.Category] = row.Count
+	}
+	return counts, err
+}
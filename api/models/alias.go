@@ -0,0 +1,133 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Alias is a short code that resolves to a url. A url has one primary alias,
+// mirrored in Url.Namespace and Url.Short, and any number of others.
+type Alias struct {
+	Id    string `json:"id" gorm:"size:36;primaryKey"`
+	UrlId string `json:"urlId" gorm:"size:36;index"`
+	// custom domain the alias is served on, empty for DOMAIN
+	Domain    string `json:"domain,omitempty" gorm:"size:253;default:'';uniqueIndex:idx_aliases_domain_namespace_short,priority:1"`
+	Namespace string `json:"namespace,omitempty" gorm:"size:32;default:'';uniqueIndex:idx_aliases_domain_namespace_short,priority:2"`
+	Short     string `json:"short" gorm:"size:64;uniqueIndex:idx_aliases_domain_namespace_short,priority:3"`
+	// primary is a reserved word in sql
+	Primary   bool      `json:"primary" gorm:"column:is_primary"`
+	Custom    bool      `json:"custom"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (Alias) TableName() string {
+	return "aliases"
+}
+
+func (alias *Alias) CreateAlias(tx *gorm.DB) error {
+	if alias.Id == "" {
+		alias.Id = uuid.New().String()
+	}
+	if alias.UrlId == "" {
+		return errors.New("urlId is required")
+	}
+	if alias.Short == "" {
+		return errors.New("shortUrl is required")
+	}
+	return tx.Create(alias).Error
+}
+
+// Key identifies the alias across domains and namespaces like Url.Key
+func (alias *Alias) Key() string {
+	return shortKey(alias.Domain, alias.Namespace, alias.Short)
+}
+
+func (alias *Alias) GetAliasByShort(tx *gorm.DB) error {
+	if alias.Short == "" {
+		return errors.New("shortUrl is required")
+	}
+	return tx.Where("domain = ? AND namespace = ? AND short = ?", alias.Domain, alias.Namespace, FoldShort(alias.Short)).First(alias).Error
+}
+
+// GetAliasesByUrlId returns the aliases of a url, primary first
+func GetAliasesByUrlId(tx *gorm.DB, urlId string) ([]Alias, error) {
+	aliases := []Alias{}
+	err := tx.Where("url_id = ?", urlId).Order("is_primary DESC, created_at").Find(&aliases).Error
+	return aliases, err
+}
+
+func GetAliasesByUrlIds(tx *gorm.DB, urlIds []string) ([]Alias, error) {
+	aliases := []Alias{}
+	if len(urlIds) == 0 {
+		return aliases, nil
+	}
+	err := tx.Where("url_id IN ?", urlIds).Order("created_at").Find(&aliases).Error
+	return aliases, err
+}
+
+// GetAliasKeysByUrlIds returns the keys of every alias of the urls
+func GetAliasKeysByUrlIds(tx *gorm.DB, urlIds []string) ([]string, error) {
+	aliases, err := GetAliasesByUrlIds(tx, urlIds)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, alias := range aliases {
+		keys = append(keys, alias.Key())
+	}
+	return keys, nil
+}
+
+// SetPrimaryAlias marks the alias as the primary alias of its url and copies
+// its code to the url
+func SetPrimaryAlias(tx *gorm.DB, alias *Alias) error {
+	err := tx.Model(&Alias{}).Where("url_id = ?", alias.UrlId).Update("is_primary", gorm.Expr("(id = ?)", alias.Id)).Error
+	if err != nil {
+		return err
+	}
+	alias.Primary = true
+	return tx.Model(&Url{}).Where("id = ?", alias.UrlId).Updates(map[string]interface{}{
+		"domain":    alias.Domain,
+		"namespace": alias.Namespace,
+		"short":     alias.Short,
+		"custom":    alias.Custom,
+	}).Error
+}
+
+// CountAliasesByDomain counts the aliases served on a custom domain
+func CountAliasesByDomain(tx *gorm.DB, domain string) (int64, error) {
+	var count int64
+	err := tx.Model(&Alias{}).Where("domain = ?", domain).Count(&count).Error
+	return count, err
+}
+
+// DeleteAlias permanently deletes the alias, its clicks still count for the url
+func (alias *Alias) DeleteAlias(tx *gorm.DB) error {
+	if alias.Id == "" {
+		return errors.New("id is required")
+	}
+	return tx.Where("id = ?", alias.Id).Delete(&Alias{}).Error
+}
+
+func DeleteAliasesByUrlIds(tx *gorm.DB, urlIds []string) error {
+	if len(urlIds) == 0 {
+		return nil
+	}
+	return tx.Where("url_id IN ?", urlIds).Delete(&Alias{}).Error
+}
+
+// BackfillAliases creates the primary alias of urls created before aliases
+// existed and attributes their clicks to it
+func BackfillAliases(tx *gorm.DB) error {
+	err := tx.Exec(`INSERT INTO aliases (id, url_id, namespace, short, is_primary, custom, created_at)
+		SELECT UUID(), urls.id, urls.namespace, urls.short, TRUE, urls.custom, urls.created_at FROM urls
+		WHERE NOT EXISTS (SELECT 1 FROM aliases WHERE aliases.url_id = urls.id)`).Error
+	if err != nil {
+		return err
+	}
+	return tx.Exec(`UPDATE url_clicks JOIN aliases ON aliases.url_id = url_clicks.url_id AND aliases.is_primary
+		SET url_clicks.alias_id = aliases.id WHERE url_clicks.alias_id = ''`).Error
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// audit actions
+const (
+	AUDIT_USER_CREATE      = "user.create"
+	AUDIT_USER_LOGIN       = "user.login"
+	AUDIT_USER_LOGOUT      = "user.logout"
+	AUDIT_USER_UPDATE      = "user.update"
+	AUDIT_USER_PASSWORD    = "user.password_change"
+	AUDIT_USER_DELETE      = "user.delete"
+	AUDIT_USER_ROLE        = "user.role_change"
+	AUDIT_USER_STATUS      = "user.status_change"
+	AUDIT_USER_PLAN        = "user.plan_change"
+	AUDIT_USER_2FA_ENABLE  = "user.2fa_enable"
+	AUDIT_USER_2FA_DISABLE = "user.2fa_disable"
+	AUDIT_URL_SHORTEN      = "url.shorten"
+	AUDIT_URL_DELETE       = "url.delete"
+	AUDIT_URL_TAKEDOWN     = "url.takedown"
+	AUDIT_URL_RESTORE      = "url.restore"
+	AUDIT_URL_SUSPEND      = "url.suspend"
+	AUDIT_REPORTS_DISMISS  = "url.reports_dismiss"
+	AUDIT_ALIAS_ADD        = "url.alias_add"
+	AUDIT_ALIAS_REMOVE     = "url.alias_remove"
+	AUDIT_ALIAS_PRIMARY    = "url.alias_primary"
+	AUDIT_MEMBER_ADD       = "workspace.member_add"
+	AUDIT_MEMBER_ROLE      = "workspace.member_role_change"
+	AUDIT_MEMBER_REMOVE    = "workspace.member_remove"
+	AUDIT_WORKSPACE_PLAN   = "workspace.plan_change"
+	AUDIT_RESERVED_ADD     = "reserved_word.add"
+	AUDIT_RESERVED_REMOVE  = "reserved_word.remove"
+	AUDIT_DOMAIN_ADD       = "domain.add"
+	AUDIT_DOMAIN_VERIFY    = "domain.verify"
+	AUDIT_DOMAIN_REMOVE    = "domain.remove"
+)
+
+// audit target types
+const (
+	AUDIT_TARGET_USER      = "user"
+	AUDIT_TARGET_URL       = "url"
+	AUDIT_TARGET_WORKSPACE = "workspace"
+	AUDIT_TARGET_RESERVED  = "reserved_word"
+	AUDIT_TARGET_DOMAIN    = "domain"
+)
+
+// AuditEvent is an append-only record of a change, Before and After hold the
+// changed values as JSON
+type AuditEvent struct {
+	Id         string    `json:"id" gorm:"size:36;primaryKey"`
+	ActorId    string    `json:"actorId" gorm:"size:36;index"`
+	ActorIp    string    `json:"actorIp" gorm:"size:45"`
+	Action     string    `json:"action" gorm:"size:64;index"`
+	TargetType string    `json:"targetType" gorm:"size:32;index:idx_audit_target"`
+	TargetId   string    `json:"targetId" gorm:"size:36;index:idx_audit_target"`
+	Before     string    `json:"before,omitempty" gorm:"type:text"`
+	After      string    `json:"after,omitempty" gorm:"type:text"`
+	CreatedAt  time.Time `json:"createdAt" gorm:"index"`
+}
+
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+func (event *AuditEvent) CreateAuditEvent(tx *gorm.DB) error {
+	if event.Id == "" {
+		event.Id = uuid.New().String()
+	}
+	if event.Action == "" {
+		return errors.New("action is required")
+	}
+	return tx.Create(event).Error
+}
+
+// AnonymizeAuditEventsBefore clears the actor ip of events older than before
+func AnonymizeAuditEventsBefore(tx *gorm.DB, before time.Time) (int64, error) {
+	result := tx.Model(&AuditEvent{}).Where("created_at < ? AND actor_ip <> ''", before).Update("actor_ip", "")
+	return result.RowsAffected, result.Error
+}
+
+// DeleteAuditEventsByUserId erases the events caused by the user or about
+// their account, only used to honour erasure requests
+func DeleteAuditEventsByUserId(tx *gorm.DB, userId string) error {
+	return tx.Where("actor_id = ? OR (target_type = ? AND target_id = ?)", userId, AUDIT_TARGET_USER, userId).
+		Delete(&AuditEvent{}).Error
+}
+
+// AuditFilter narrows an audit event query, empty fields match everything
+type AuditFilter struct {
+	ActorId    string
+	Action     string
+	TargetType string
+	TargetId   string
+	From       time.Time
+	To         time.Time
+}
+
+// QueryAuditEvents returns a query for the events matching the filter, newest first
+func QueryAuditEvents(tx *gorm.DB, filter AuditFilter) *gorm.DB {
+	query := tx.Model(&AuditEvent{})
+	if filter.ActorId != "" {
+		query = query.Where("actor_id = ?", filter.ActorId)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetId != "" {
+		query = query.Where("target_id = ?", filter.TargetId)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	return query.Order("created_at DESC")
+}
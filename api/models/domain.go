@@ -0,0 +1,97 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Domain is a custom domain a user serves links on once verified. Several
+// users may add the same host, only one can verify it.
+type Domain struct {
+	Id     string `json:"id" gorm:"size:36;primaryKey"`
+	UserId string `json:"userId" gorm:"size:36;index"`
+	Host   string `json:"host" gorm:"size:253;index"`
+	// secret the user publishes to prove ownership
+	Token      string     `json:"token" gorm:"size:64"`
+	VerifiedAt *time.Time `json:"verifiedAt"`
+	// host once verified, the unique index lets only one user verify a host
+	VerifiedHost *string   `json:"-" gorm:"size:253;uniqueIndex"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+func (Domain) TableName() string {
+	return "domains"
+}
+
+func (domain *Domain) CreateDomain(tx *gorm.DB) error {
+	if domain.Id == "" {
+		domain.Id = uuid.New().String()
+	}
+	if domain.UserId == "" {
+		return errors.New("userId is required")
+	}
+	if domain.Host == "" {
+		return errors.New("host is required")
+	}
+	return tx.Create(domain).Error
+}
+
+func (domain *Domain) IsVerified() bool {
+	return domain.VerifiedAt != nil
+}
+
+// GetUserDomain loads a domain of the user by id
+func (domain *Domain) GetUserDomain(tx *gorm.DB) error {
+	if domain.Id == "" {
+		return errors.New("id is required")
+	}
+	return tx.Where("id = ? AND user_id = ?", domain.Id, domain.UserId).First(domain).Error
+}
+
+// GetVerifiedDomain loads the verified domain with the host
+func (domain *Domain) GetVerifiedDomain(tx *gorm.DB) error {
+	if domain.Host == "" {
+		return errors.New("host is required")
+	}
+	return tx.Where("verified_host = ?", domain.Host).First(domain).Error
+}
+
+// GetDomainsByUserId returns the domains of the user, oldest first
+func GetDomainsByUserId(tx *gorm.DB, userId string) ([]Domain, error) {
+	domains := []Domain{}
+	err := tx.Where("user_id = ?", userId).Order("created_at").Find(&domains).Error
+	return domains, err
+}
+
+// GetVerifiedHosts returns the host of every verified domain
+func GetVerifiedHosts(tx *gorm.DB) ([]string, error) {
+	hosts := []string{}
+	err := tx.Model(&Domain{}).Where("verified_host IS NOT NULL").Pluck("verified_host", &hosts).Error
+	return hosts, err
+}
+
+// MarkVerified verifies the domain, gorm.ErrDuplicatedKey when another user
+// verified the host first
+func (domain *Domain) MarkVerified(tx *gorm.DB) error {
+	now := time.Now()
+	err := tx.Model(domain).Updates(map[string]interface{}{"verified_at": now, "verified_host": domain.Host}).Error
+	if err != nil {
+		return err
+	}
+	domain.VerifiedAt, domain.VerifiedHost = &now, &domain.Host
+	return nil
+}
+
+func (domain *Domain) DeleteDomain(tx *gorm.DB) error {
+	if domain.Id == "" {
+		return errors.New("id is required")
+	}
+	return tx.Where("id = ?", domain.Id).Delete(&Domain{}).Error
+}
+
+func DeleteDomainsByUserId(tx *gorm.DB, userId string) error {
+	return tx.Where("user_id = ?", userId).Delete(&Domain{}).Error
+}
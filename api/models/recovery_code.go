@@ -0,0 +1,58 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type RecoveryCode struct {
+	gorm.Model
+	Id       string     `json:"id"`
+	UserId   string     `json:"userId" gorm:"index"`
+	CodeHash string     `json:"-"`
+	UsedAt   *time.Time `json:"usedAt"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// ReplaceRecoveryCodes removes any existing codes of the user and stores the new hashes
+func ReplaceRecoveryCodes(tx *gorm.DB, userId string, hashes []string) error {
+	if userId == "" {
+		return errors.New("userId is required")
+	}
+	if err := DeleteRecoveryCodesByUserId(tx, userId); err != nil {
+		return err
+	}
+	codes := make([]RecoveryCode, len(hashes))
+	for i, hash := range hashes {
+		codes[i] = RecoveryCode{
+			Id:       uuid.New().String(),
+			UserId:   userId,
+			CodeHash: hash,
+		}
+	}
+	return tx.Create(&codes).Error
+}
+
+// UseRecoveryCode marks an unused code as used, failing if none matches
+func UseRecoveryCode(tx *gorm.DB, userId string, hash string) error {
+	result := tx.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userId, hash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("invalid recovery code")
+	}
+	return nil
+}
+
+func DeleteRecoveryCodesByUserId(tx *gorm.DB, userId string) error {
+	return tx.Unscoped().Where("user_id = ?", userId).Delete(&RecoveryCode{}).Error
+}
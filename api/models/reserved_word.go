@@ -0,0 +1,50 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ReservedWord is a word or glob pattern added by an admin that custom short
+// codes and handles may not use
+type ReservedWord struct {
+	Id        string    `json:"id" gorm:"size:36;primaryKey"`
+	Pattern   string    `json:"pattern" gorm:"size:64;uniqueIndex"`
+	CreatedBy string    `json:"createdBy" gorm:"size:36"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (ReservedWord) TableName() string {
+	return "reserved_words"
+}
+
+func (word *ReservedWord) CreateReservedWord(tx *gorm.DB) error {
+	if word.Id == "" {
+		word.Id = uuid.New().String()
+	}
+	if word.Pattern == "" {
+		return errors.New("pattern is required")
+	}
+	return tx.Create(word).Error
+}
+
+// GetReservedWords returns every admin added reserved word, oldest first
+func GetReservedWords(tx *gorm.DB) ([]ReservedWord, error) {
+	words := []ReservedWord{}
+	err := tx.Order("created_at").Find(&words).Error
+	return words, err
+}
+
+// DeleteReservedWord deletes the word and loads it into word
+func (word *ReservedWord) DeleteReservedWord(tx *gorm.DB) error {
+	if word.Id == "" {
+		return errors.New("id is required")
+	}
+	if err := tx.Where("id = ?", word.Id).First(word).Error; err != nil {
+		return err
+	}
+	return tx.Delete(word).Error
+}
@@ -2,19 +2,50 @@ package models
 
 import (
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+const (
+	URL_STATUS_ACTIVE     = "active"
+	URL_STATUS_TAKEN_DOWN = "taken_down"
+	// suspended links stop resolving until a moderator reviews their reports
+	URL_STATUS_SUSPENDED = "suspended"
+)
+
+// collations of urls.short, the unique index follows the collation
+const (
+	SHORT_COLLATION_CASE_SENSITIVE   = "utf8mb4_bin"
+	SHORT_COLLATION_CASE_INSENSITIVE = "utf8mb4_general_ci"
+)
+
+// CaseInsensitiveShorts makes /Promo and /promo the same link
+var CaseInsensitiveShorts bool
+
 type Url struct {
 	gorm.Model
-	Id     string    `json:"id"`
-	UserId string    `json:"userId"`
-	Long   string    `json:"long"`
-	Short  string    `json:"short"`
-	Expiry time.Time `json:"expiry"`
+	Id          string `json:"id"`
+	UserId      string `json:"userId"`
+	WorkspaceId string `json:"workspaceId" gorm:"size:36;index;default:''"`
+	Long        string `json:"long"`
+	// custom domain of the link, empty for DOMAIN
+	Domain string `json:"domain,omitempty" gorm:"size:253;default:'';uniqueIndex:idx_urls_domain_namespace_short,priority:1"`
+	// handle of the owner for links under /u/{handle}/, empty for global links.
+	// Domain, Namespace, Short and Custom mirror the primary alias.
+	Namespace      string    `json:"namespace,omitempty" gorm:"size:32;default:'';uniqueIndex:idx_urls_domain_namespace_short,priority:2"`
+	Short          string    `json:"short" gorm:"size:64;uniqueIndex:idx_urls_domain_namespace_short,priority:3"`
+	Expiry         time.Time `json:"expiry"`
+	Status         string    `json:"status" gorm:"default:active"`
+	TakedownReason string    `json:"takedownReason,omitempty"`
+	// created with a custom alias instead of a generated code
+	Custom bool `json:"custom"`
+	// destination policy rule the long url matched when it was last resolved
+	PolicyMatch string `json:"policyMatch,omitempty"`
+	// keyed hash of the owner and long url of links with a derived code
+	Fingerprint string `json:"-" gorm:"size:64;index;default:''"`
 }
 
 func (Url) TableName() string {
@@ -31,26 +62,271 @@ func (url *Url) CreateUrl(tx *gorm.DB) error {
 	if url.Expiry.IsZero() {
 		url.Expiry = time.Now().Add(time.Hour * 24 * 30) // 30 days
 	}
+	if url.Status == "" {
+		url.Status = URL_STATUS_ACTIVE
+	}
 	return tx.Create(url).Error
 }
 
+// GetUrlByShort loads the url that any of its aliases with the domain,
+// namespace and short code of url points to
 func (url *Url) GetUrlByShort(tx *gorm.DB) error {
-	if url.Short == "" {
-		return errors.New("shortUrl is required")
+	alias := &Alias{Domain: url.Domain, Namespace: url.Namespace, Short: url.Short}
+	return url.GetUrlByAlias(tx, alias)
+}
+
+// GetUrlByAlias loads the alias by its namespace and short code and the url it
+// points to
+func (url *Url) GetUrlByAlias(tx *gorm.DB, alias *Alias) error {
+	if err := alias.GetAliasByShort(tx); err != nil {
+		return err
 	}
-	return tx.Where("short = ?", url.Short).First(url).Error
+	return tx.Where("id = ?", alias.UrlId).First(url).Error
 }
 
-func (url *Url) DeleteUrl(tx *gorm.DB) error {
+// GetUrlByFingerprint loads the newest active, unexpired url of the user or
+// workspace with the fingerprint
+func (url *Url) GetUrlByFingerprint(tx *gorm.DB, userId string, workspaceId string) error {
+	if url.Fingerprint == "" {
+		return errors.New("fingerprint is required")
+	}
+	return scopedUrls(tx, userId, workspaceId).
+		Where("fingerprint = ? AND status = ? AND expiry > ?", url.Fingerprint, URL_STATUS_ACTIVE, time.Now()).
+		Order("created_at DESC").First(url).Error
+}
+
+// GetScopedUrl loads a url by id when it belongs to the workspace, or is a
+// personal url of the user when workspaceId is empty
+func (url *Url) GetScopedUrl(tx *gorm.DB, userId string, workspaceId string) error {
 	if url.Id == "" {
 		return errors.New("id is required")
 	}
-	result := tx.Unscoped().Where("id = ? AND user_id = ?", url.Id, url.UserId).Delete(&Url{})
-	if result.Error != nil {
-		return result.Error
+	return scopedUrls(tx, userId, workspaceId).Where("id = ?", url.Id).First(url).Error
+}
+
+// Key identifies a link across domains and namespaces, the short code of
+// global links and handle/short of namespaced ones, prefixed with the host on
+// custom domains
+func (url *Url) Key() string {
+	return shortKey(url.Domain, url.Namespace, url.Short)
+}
+
+func shortKey(domain string, namespace string, short string) string {
+	key := short
+	if namespace != "" {
+		key = namespace + "/" + short
+	}
+	if domain != "" {
+		// hosts contain dots, handles cannot
+		key = domain + "/" + key
+	}
+	return key
+}
+
+// MigrateUrlIndexes drops the earlier unique indexes on short codes, codes are
+// unique per domain and namespace now
+func MigrateUrlIndexes(tx *gorm.DB) error {
+	indexes := []struct {
+		model interface{}
+		name  string
+	}{
+		{&Url{}, "idx_urls_short"},
+		{&Url{}, "idx_urls_namespace_short"},
+		{&Alias{}, "idx_aliases_namespace_short"},
+	}
+	for _, index := range indexes {
+		if tx.Migrator().HasIndex(index.model, index.name) {
+			if err := tx.Migrator().DropIndex(index.model, index.name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FoldShort returns the form of a short code used for lookups, lowercased in
+// case-insensitive mode
+func FoldShort(short string) string {
+	if CaseInsensitiveShorts {
+		return strings.ToLower(short)
+	}
+	return short
+}
+
+// SetShortCaseInsensitive switches the collation of urls.short and
+// aliases.short, so the unique indexes and lookups compare codes with or
+// without case. Switching to case-insensitive fails while codes exist that
+// only differ in case.
+func SetShortCaseInsensitive(tx *gorm.DB, caseInsensitive bool) error {
+	collation := SHORT_COLLATION_CASE_SENSITIVE
+	if caseInsensitive {
+		collation = SHORT_COLLATION_CASE_INSENSITIVE
 	}
-	if result.RowsAffected == 0 {
-		return errors.New("url not found")
+	for _, table := range []string{Url{}.TableName(), Alias{}.TableName()} {
+		var current string
+		err := tx.Raw("SELECT COLLATION_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?", table, "short").Scan(&current).Error
+		if err != nil {
+			return err
+		}
+		if current != collation {
+			if err := tx.Exec("ALTER TABLE " + table + " MODIFY short VARCHAR(64) CHARACTER SET utf8mb4 COLLATE " + collation).Error; err != nil {
+				return err
+			}
+		}
 	}
+	CaseInsensitiveShorts = caseInsensitive
 	return nil
 }
+
+// DeleteUrl deletes a url owned by the workspace when WorkspaceId is set,
+// otherwise a personal url of UserId. The deleted url is loaded into url.
+func (url *Url) DeleteUrl(tx *gorm.DB) error {
+	if url.Id == "" {
+		return errors.New("id is required")
+	}
+	query := tx.Where("id = ?", url.Id)
+	if url.WorkspaceId != "" {
+		query = query.Where("workspace_id = ?", url.WorkspaceId)
+	} else {
+		query = query.Where("user_id = ? AND workspace_id = ''", url.UserId)
+	}
+	if err := query.First(url).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("url not found")
+		}
+		return err
+	}
+	if err := DeleteAliasesByUrlIds(tx, []string{url.Id}); err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(url).Error
+}
+
+func (url *Url) IsActive() bool {
+	return url.Status == "" || url.Status == URL_STATUS_ACTIVE
+}
+
+// scopedUrls selects the urls of a workspace, or the personal urls of the user
+// when workspaceId is empty
+func scopedUrls(tx *gorm.DB, userId string, workspaceId string) *gorm.DB {
+	if workspaceId != "" {
+		return tx.Model(&Url{}).Where("workspace_id = ?", workspaceId)
+	}
+	return tx.Model(&Url{}).Where("user_id = ? AND workspace_id = ''", userId)
+}
+
+// CountActiveUrls counts the active, unexpired urls of a user or workspace
+func CountActiveUrls(tx *gorm.DB, userId string, workspaceId string) (int64, error) {
+	var count int64
+	err := scopedUrls(tx, userId, workspaceId).
+		Where("status = ? AND expiry > ?", URL_STATUS_ACTIVE, time.Now()).Count(&count).Error
+	return count, err
+}
+
+// CountGeneratedUrls counts the urls with a generated code across all users
+func CountGeneratedUrls(tx *gorm.DB) (int64, error) {
+	var count int64
+	err := tx.Model(&Url{}).Where("custom = ?", false).Count(&count).Error
+	return count, err
+}
+
+// CountCustomAliases counts the custom aliases of the urls of a user or workspace
+func CountCustomAliases(tx *gorm.DB, userId string, workspaceId string) (int64, error) {
+	var count int64
+	err := scopedUrls(tx, userId, workspaceId).
+		Joins("JOIN aliases ON aliases.url_id = urls.id").
+		Where("aliases.custom = ?", true).Count(&count).Error
+	return count, err
+}
+
+// GetUrlKeysByUserId returns the keys of every alias of the urls owned by the user
+func GetUrlKeysByUserId(tx *gorm.DB, userId string) ([]string, error) {
+	var ids []string
+	if err := tx.Model(&Url{}).Where("user_id = ?", userId).Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return GetAliasKeysByUrlIds(tx, ids)
+}
+
+// GetTakenShorts returns which of the given short codes are already in use in
+// the namespace on the domain, empty for global codes on DOMAIN
+func GetTakenShorts(tx *gorm.DB, domain string, namespace string, shorts []string) ([]string, error) {
+	var taken []string
+	if len(shorts) == 0 {
+		return taken, nil
+	}
+	err := tx.Model(&Alias{}).Where("domain = ? AND namespace = ? AND short IN ?", domain, namespace, shorts).Pluck("short", &taken).Error
+	return taken, err
+}
+
+// RenameNamespace moves the namespaced aliases of the urls of a user to a new
+// handle and returns the keys they had under the old handle
+func RenameNamespace(tx *gorm.DB, userId string, from string, to string) ([]string, error) {
+	keys := []string{}
+	if from == "" {
+		return keys, nil
+	}
+	owned := tx.Model(&Url{}).Select("id").Where("user_id = ?", userId)
+	aliases := []Alias{}
+	if err := tx.Where("namespace = ? AND url_id IN (?)", from, owned).Find(&aliases).Error; err != nil {
+		return nil, err
+	}
+	if len(aliases) == 0 {
+		return keys, nil
+	}
+	for _, alias := range aliases {
+		keys = append(keys, alias.Key())
+	}
+	err := tx.Model(&Alias{}).Where("namespace = ? AND url_id IN (?)", from, owned).Update("namespace", to).Error
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Model(&Url{}).Where("user_id = ? AND namespace = ?", userId, from).Update("namespace", to).Error
+	return keys, err
+}
+
+// GetUrlsByUserId returns every url created by the user, personal or in a workspace
+func GetUrlsByUserId(tx *gorm.DB, userId string) ([]Url, error) {
+	urls := []Url{}
+	err := tx.Where("user_id = ?", userId).Order("created_at").Find(&urls).Error
+	return urls, err
+}
+
+// GetPersonalUrlsByUserId returns the urls of the user that do not belong to a workspace
+func GetPersonalUrlsByUserId(tx *gorm.DB, userId string) ([]Url, error) {
+	urls := []Url{}
+	err := tx.Where("user_id = ? AND workspace_id = ''", userId).Find(&urls).Error
+	return urls, err
+}
+
+func GetUrlsByWorkspaceId(tx *gorm.DB, workspaceId string) ([]Url, error) {
+	urls := []Url{}
+	err := tx.Where("workspace_id = ?", workspaceId).Find(&urls).Error
+	return urls, err
+}
+
+// DeleteUrlsWithClicks permanently deletes the urls with all of their aliases, clicks and reports
+func DeleteUrlsWithClicks(tx *gorm.DB, urls []Url) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	ids := make([]string, len(urls))
+	for i, url := range urls {
+		ids[i] = url.Id
+	}
+	if err := DeleteClicksByUrlIds(tx, ids); err != nil {
+		return err
+	}
+	if err := DeleteReportsByUrlIds(tx, ids); err != nil {
+		return err
+	}
+	if err := DeleteAliasesByUrlIds(tx, ids); err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("id IN ?", ids).Delete(&Url{}).Error
+}
+
+// SetPolicyMatch records the destination policy rule the url matches, empty when it is clean
+func (url *Url) SetPolicyMatch(tx *gorm.DB, match string) error {
+	return tx.Model(&Url{}).Where("id = ?", url.Id).Update("policy_match", match).Error
+}
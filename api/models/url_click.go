@@ -2,6 +2,7 @@ package models
 
 import (
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -9,8 +10,10 @@ import (
 
 type UrlClick struct {
 	gorm.Model
-	Id        string `json:"id"`
-	UrlId     string `json:"urlId" gorm:"index"`
+	Id    string `json:"id"`
+	UrlId string `json:"urlId" gorm:"index"`
+	// alias the visitor opened the url through
+	AliasId   string `json:"aliasId" gorm:"size:36;index;default:''"`
 	IpAddress string `json:"ipAddress"`
 }
 
@@ -33,3 +36,44 @@ func GetClickCountByUrlId(tx *gorm.DB, urlId string) (int64, error) {
 	err := tx.Model(&UrlClick{}).Where("url_id = ?", urlId).Count(&count).Error
 	return count, err
 }
+
+// GetClickCountsByAlias counts the clicks of a url per alias id
+func GetClickCountsByAlias(tx *gorm.DB, urlId string) (map[string]int64, error) {
+	rows := []struct {
+		AliasId string
+		Count   int64
+	}{}
+	err := tx.Model(&UrlClick{}).Select("alias_id, COUNT(*) AS count").
+		Where("url_id = ?", urlId).Group("alias_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.AliasId] = row.Count
+	}
+	return counts, nil
+}
+
+func GetClicksByUrlIds(tx *gorm.DB, urlIds []string) ([]UrlClick, error) {
+	clicks := []UrlClick{}
+	if len(urlIds) == 0 {
+		return clicks, nil
+	}
+	err := tx.Where("url_id IN ?", urlIds).Order("created_at").Find(&clicks).Error
+	return clicks, err
+}
+
+// AnonymizeClicksBefore clears the visitor ip of clicks older than before,
+// click counts are kept
+func AnonymizeClicksBefore(tx *gorm.DB, before time.Time) (int64, error) {
+	result := tx.Model(&UrlClick{}).Where("created_at < ? AND ip_address <> ''", before).Update("ip_address", "")
+	return result.RowsAffected, result.Error
+}
+
+func DeleteClicksByUrlIds(tx *gorm.DB, urlIds []string) error {
+	if len(urlIds) == 0 {
+		return nil
+	}
+	return tx.Unscoped().Where("url_id IN ?", urlIds).Delete(&UrlClick{}).Error
+}
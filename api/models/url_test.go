@@ -0,0 +1,77 @@
+package models
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+func TestKeyIsUniquePerDomain(t *testing.T) {
+	keys := map[string]bool{}
+	for _, alias := range []Alias{
+		{Short: "launch"},
+		{Namespace: "alice", Short: "launch"},
+		{Domain: "go.example.com", Short: "launch"},
+		{Domain: "go.example.com", Namespace: "alice", Short: "launch"},
+		{Domain: "links.example.org", Short: "launch"},
+	} {
+		key := alias.Key()
+		if keys[key] {
+			t.Errorf("Key() of %+v = %q, already used", alias, key)
+		}
+		keys[key] = true
+		url := Url{Domain: alias.Domain, Namespace: alias.Namespace, Short: alias.Short}
+		if url.Key() != key {
+			t.Errorf("Url.Key() = %q, Alias.Key() = %q", url.Key(), key)
+		}
+	}
+}
+
+func TestShortIndexesIncludeDomain(t *testing.T) {
+	for name, model := range map[string]interface{}{
+		"idx_urls_domain_namespace_short":    &Url{},
+		"idx_aliases_domain_namespace_short": &Alias{},
+	} {
+		parsed, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := parsed.LookIndex(name)
+		if index == nil {
+			t.Fatalf("index %s not found", name)
+		}
+		columns := []string{}
+		for _, field := range index.Fields {
+			columns = append(columns, field.DBName)
+		}
+		if index.Class != "UNIQUE" || !slices.Equal(columns, []string{"domain", "namespace", "short"}) {
+			t.Errorf("index %s = %s %v, want UNIQUE [domain namespace short]", name, index.Class, columns)
+		}
+	}
+}
+
+func TestShortLookupsFilterByDomain(t *testing.T) {
+	db, err := gorm.Open(mysql.New(mysql.Config{SkipInitializeWithVersion: true}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queries []string
+	db.Callback().Query().After("gorm:query").Register("test:capture", func(tx *gorm.DB) {
+		queries = append(queries, tx.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...))
+	})
+	alias := &Alias{Domain: "go.example.com", Namespace: "alice", Short: "launch"}
+	alias.GetAliasByShort(db)
+	GetTakenShorts(db, "go.example.com", "", []string{"launch"})
+	want := []string{
+		"SELECT * FROM `aliases` WHERE domain = 'go.example.com' AND namespace = 'alice' AND short = 'launch' ORDER BY `aliases`.`id` LIMIT 1",
+		"SELECT `short` FROM `aliases` WHERE domain = 'go.example.com' AND namespace = '' AND short IN ('launch')",
+	}
+	if !slices.Equal(queries, want) {
+		t.Errorf("queries = %q, want %q", queries, want)
+	}
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MonthlyUsage counts the links created by a user or workspace in a calendar
+// month. Deleting links does not give the quota back.
+type MonthlyUsage struct {
+	Id           string `json:"id" gorm:"size:36;primaryKey"`
+	ScopeId      string `json:"scopeId" gorm:"size:36;uniqueIndex:idx_usage_scope_month"`
+	Month        string `json:"month" gorm:"size:7;uniqueIndex:idx_usage_scope_month"`
+	LinksCreated int64  `json:"linksCreated"`
+}
+
+func (MonthlyUsage) TableName() string {
+	return "monthly_usages"
+}
+
+// UsageMonth returns the month key, e.g. 2024-01, of t in UTC
+func UsageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// GetLinksCreatedThisMonth returns the number of links the scope, a user or
+// workspace id, created this month
+func GetLinksCreatedThisMonth(tx *gorm.DB, scopeId string) (int64, error) {
+	usage := MonthlyUsage{}
+	err := tx.Where("scope_id = ? AND month = ?", scopeId, UsageMonth(time.Now())).Limit(1).Find(&usage).Error
+	return usage.LinksCreated, err
+}
+
+// LockScope locks the row of the workspace, or of the user for personal links,
+// until the transaction ends so quota checks of the scope run one at a time
+func LockScope(tx *gorm.DB, userId string, workspaceId string) error {
+	locking := tx.Clauses(clause.Locking{Strength: "UPDATE"})
+	if workspaceId != "" {
+		return locking.Select("id").Where("id = ?", workspaceId).First(&Workspace{}).Error
+	}
+	return locking.Select("id").Where("id = ?", userId).First(&User{}).Error
+}
+
+// IncrementLinksCreated counts a new link for the scope in the current month
+func IncrementLinksCreated(tx *gorm.DB, scopeId string) error {
+	usage := &MonthlyUsage{
+		Id:           uuid.New().String(),
+		ScopeId:      scopeId,
+		Month:        UsageMonth(time.Now()),
+		LinksCreated: 1,
+	}
+	return tx.Clauses(clause.OnConflict{
+		DoUpdates: clause.Assignments(map[string]interface{}{"links_created": gorm.Expr("links_created + 1")}),
+	}).Create(usage).Error
+}
+
+func DeleteUsageByScopeId(tx *gorm.DB, scopeId string) error {
+	return tx.Where("scope_id = ?", scopeId).Delete(&MonthlyUsage{}).Error
+}
@@ -7,12 +7,28 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	ROLE_USER  = "user"
+	ROLE_ADMIN = "admin"
+)
+
 type User struct {
 	gorm.Model
 	Id       string `json:"id"`
 	Name     string `json:"name"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// pending or active totp secret, only trusted once TotpEnabled is set
+	TotpSecret  string `json:"-"`
+	TotpEnabled bool   `json:"totpEnabled"`
+	Role        string `json:"role" gorm:"default:user"`
+	Disabled    bool   `json:"disabled"`
+	// bumped to revoke every session issued before the change
+	TokenVersion int `json:"-"`
+	// plan that caps the user's personal links, empty for the default plan
+	Plan string `json:"plan" gorm:"size:32;default:''"`
+	// claimed namespace for links under /u/{handle}/, nil until claimed
+	Handle *string `json:"handle" gorm:"size:32;uniqueIndex"`
 }
 
 func (User) TableName() string {
@@ -32,6 +48,9 @@ func (user *User) CreateUser(tx *gorm.DB) error {
 	if user.Password == "" {
 		return errors.New("password is required")
 	}
+	if user.Role == "" {
+		user.Role = ROLE_USER
+	}
 	return tx.Create(user).Error
 }
 
@@ -48,3 +67,52 @@ func (user *User) GetUserByEmail(tx *gorm.DB) error {
 	}
 	return tx.Where("email = ?", user.Email).First(user).Error
 }
+
+func (user *User) GetUserByHandle(tx *gorm.DB) error {
+	if user.Handle == nil || *user.Handle == "" {
+		return errors.New("handle is required")
+	}
+	return tx.Where("handle = ?", *user.Handle).First(user).Error
+}
+
+// HandleName returns the claimed handle, empty when there is none
+func (user *User) HandleName() string {
+	if user.Handle == nil {
+		return ""
+	}
+	return *user.Handle
+}
+
+func (user *User) IsAdmin() bool {
+	return user.Role == ROLE_ADMIN
+}
+
+func IsValidRole(role string) bool {
+	return role == ROLE_USER || role == ROLE_ADMIN
+}
+
+// BootstrapAdmins grants the admin role to existing users with the given
+// emails while there is no admin yet. Once one exists roles are only changed
+// by admins, so demoted users are not promoted again.
+func BootstrapAdmins(tx *gorm.DB, emails []string) (int64, error) {
+	if len(emails) == 0 {
+		return 0, nil
+	}
+	var admins int64
+	if err := tx.Model(&User{}).Where("role = ?", ROLE_ADMIN).Count(&admins).Error; err != nil {
+		return 0, err
+	}
+	if admins > 0 {
+		return 0, nil
+	}
+	result := tx.Model(&User{}).Where("email IN ?", emails).Update("role", ROLE_ADMIN)
+	return result.RowsAffected, result.Error
+}
+
+// DeleteUser permanently deletes the user row, related data has to be removed first
+func (user *User) DeleteUser(tx *gorm.DB) error {
+	if user.Id == "" {
+		return errors.New("id is required")
+	}
+	return tx.Unscoped().Where("id = ?", user.Id).Delete(&User{}).Error
+}
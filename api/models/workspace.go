@@ -0,0 +1,203 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	WORKSPACE_ROLE_OWNER  = "owner"
+	WORKSPACE_ROLE_EDITOR = "editor"
+	WORKSPACE_ROLE_VIEWER = "viewer"
+)
+
+// rank of each membership role, a higher rank includes the permissions of lower ones
+var workspaceRoleRank = map[string]int{
+	WORKSPACE_ROLE_VIEWER: 1,
+	WORKSPACE_ROLE_EDITOR: 2,
+	WORKSPACE_ROLE_OWNER:  3,
+}
+
+func IsValidWorkspaceRole(role string) bool {
+	_, ok := workspaceRoleRank[role]
+	return ok
+}
+
+// WorkspaceRoleAtLeast reports whether role grants the permissions of minRole
+func WorkspaceRoleAtLeast(role string, minRole string) bool {
+	return workspaceRoleRank[role] >= workspaceRoleRank[minRole]
+}
+
+type Workspace struct {
+	gorm.Model
+	Id   string `json:"id" gorm:"size:36;uniqueIndex"`
+	Name string `json:"name"`
+	// plan that caps the workspace's links, empty for the default plan
+	Plan string `json:"plan" gorm:"size:32;default:''"`
+}
+
+func (Workspace) TableName() string {
+	return "workspaces"
+}
+
+type WorkspaceMember struct {
+	gorm.Model
+	Id          string `json:"id"`
+	WorkspaceId string `json:"workspaceId" gorm:"size:36;index"`
+	UserId      string `json:"userId" gorm:"size:36;index"`
+	Role        string `json:"role"`
+}
+
+func (WorkspaceMember) TableName() string {
+	return "workspace_members"
+}
+
+type WorkspaceInvitation struct {
+	gorm.Model
+	Id          string    `json:"id"`
+	WorkspaceId string    `json:"workspaceId" gorm:"size:36;index"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"`
+	Token       string    `json:"-" gorm:"size:64;uniqueIndex"`
+	InvitedBy   string    `json:"invitedBy"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+func (WorkspaceInvitation) TableName() string {
+	return "workspace_invitations"
+}
+
+// CreateWorkspace creates the workspace and makes ownerId its first owner
+func (workspace *Workspace) CreateWorkspace(tx *gorm.DB, ownerId string) error {
+	if workspace.Id == "" {
+		workspace.Id = uuid.New().String()
+	}
+	if workspace.Name == "" {
+		return errors.New("name is required")
+	}
+	if err := tx.Create(workspace).Error; err != nil {
+		return err
+	}
+	member := &WorkspaceMember{
+		WorkspaceId: workspace.Id,
+		UserId:      ownerId,
+		Role:        WORKSPACE_ROLE_OWNER,
+	}
+	return member.CreateMember(tx)
+}
+
+func (workspace *Workspace) GetWorkspaceById(tx *gorm.DB) error {
+	if workspace.Id == "" {
+		return errors.New("id is required")
+	}
+	return tx.Where("id = ?", workspace.Id).First(workspace).Error
+}
+
+func (member *WorkspaceMember) CreateMember(tx *gorm.DB) error {
+	if member.Id == "" {
+		member.Id = uuid.New().String()
+	}
+	if member.WorkspaceId == "" {
+		return errors.New("workspaceId is required")
+	}
+	if member.UserId == "" {
+		return errors.New("userId is required")
+	}
+	if !IsValidWorkspaceRole(member.Role) {
+		return errors.New("invalid role")
+	}
+	return tx.Create(member).Error
+}
+
+func (member *WorkspaceMember) GetMember(tx *gorm.DB) error {
+	if member.WorkspaceId == "" || member.UserId == "" {
+		return errors.New("workspaceId and userId are required")
+	}
+	return tx.Where("workspace_id = ? AND user_id = ?", member.WorkspaceId, member.UserId).First(member).Error
+}
+
+func (member *WorkspaceMember) DeleteMember(tx *gorm.DB) error {
+	result := tx.Unscoped().Where("workspace_id = ? AND user_id = ?", member.WorkspaceId, member.UserId).Delete(&WorkspaceMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("member not found")
+	}
+	return nil
+}
+
+func GetMembersByWorkspaceId(tx *gorm.DB, workspaceId string) ([]WorkspaceMember, error) {
+	members := []WorkspaceMember{}
+	err := tx.Where("workspace_id = ?", workspaceId).Order("created_at ASC").Find(&members).Error
+	return members, err
+}
+
+func CountWorkspaceOwners(tx *gorm.DB, workspaceId string) (int64, error) {
+	var count int64
+	err := tx.Model(&WorkspaceMember{}).Where("workspace_id = ? AND role = ?", workspaceId, WORKSPACE_ROLE_OWNER).Count(&count).Error
+	return count, err
+}
+
+func (invitation *WorkspaceInvitation) CreateInvitation(tx *gorm.DB) error {
+	if invitation.Id == "" {
+		invitation.Id = uuid.New().String()
+	}
+	if invitation.WorkspaceId == "" {
+		return errors.New("workspaceId is required")
+	}
+	if invitation.Email == "" {
+		return errors.New("email is required")
+	}
+	if invitation.Token == "" {
+		return errors.New("token is required")
+	}
+	if !IsValidWorkspaceRole(invitation.Role) {
+		return errors.New("invalid role")
+	}
+	if invitation.ExpiresAt.IsZero() {
+		invitation.ExpiresAt = time.Now().Add(time.Hour * 24 * 7) // 7 days
+	}
+	return tx.Create(invitation).Error
+}
+
+func (invitation *WorkspaceInvitation) GetInvitationByToken(tx *gorm.DB) error {
+	if invitation.Token == "" {
+		return errors.New("token is required")
+	}
+	return tx.Where("token = ?", invitation.Token).First(invitation).Error
+}
+
+func (invitation *WorkspaceInvitation) DeleteInvitation(tx *gorm.DB) error {
+	return tx.Unscoped().Where("id = ?", invitation.Id).Delete(&WorkspaceInvitation{}).Error
+}
+
+func GetMembershipsByUserId(tx *gorm.DB, userId string) ([]WorkspaceMember, error) {
+	members := []WorkspaceMember{}
+	err := tx.Where("user_id = ?", userId).Find(&members).Error
+	return members, err
+}
+
+func CountWorkspaceMembers(tx *gorm.DB, workspaceId string) (int64, error) {
+	var count int64
+	err := tx.Model(&WorkspaceMember{}).Where("workspace_id = ?", workspaceId).Count(&count).Error
+	return count, err
+}
+
+// DeleteWorkspace permanently deletes the workspace with its members and
+// invitations, its links have to be removed by the caller
+func (workspace *Workspace) DeleteWorkspace(tx *gorm.DB) error {
+	if workspace.Id == "" {
+		return errors.New("id is required")
+	}
+	if err := tx.Unscoped().Where("workspace_id = ?", workspace.Id).Delete(&WorkspaceMember{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("workspace_id = ?", workspace.Id).Delete(&WorkspaceInvitation{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("id = ?", workspace.Id).Delete(&Workspace{}).Error
+}
@@ -0,0 +1,262 @@
+// Package policy decides whether a destination url may be shortened or
+// resolved, based on blocklists loaded from files and reloaded on change.
+package policy
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ydv-ankit/go-url-shortener/utils"
+)
+
+const RELOAD_INTERVAL_SECONDS = 30
+
+// Verdict is the result of checking a destination, Reason names the matching rule
+type Verdict struct {
+	Blocked bool
+	Reason  string
+}
+
+type pattern struct {
+	source  string
+	re      *regexp.Regexp
+	hasPath bool
+}
+
+type ruleset struct {
+	domains  map[string]bool
+	patterns []pattern
+	// hex sha256 prefixes grouped by their length
+	threatPrefixes map[int]map[string]bool
+}
+
+type Engine struct {
+	mu      sync.RWMutex
+	rules   *ruleset
+	files   map[string]string
+	modTime map[string]time.Time
+}
+
+var defaultEngine = &Engine{rules: &ruleset{}}
+
+// Start loads the policy files named by POLICY_BLOCKED_DOMAINS_FILE,
+// POLICY_BLOCKED_PATTERNS_FILE and POLICY_THREAT_LIST_FILE and reloads them in
+// the background whenever one of them changes
+func Start() {
+	defaultEngine.files = map[string]string{
+		"domains":  os.Getenv("POLICY_BLOCKED_DOMAINS_FILE"),
+		"patterns": os.Getenv("POLICY_BLOCKED_PATTERNS_FILE"),
+		"threats":  os.Getenv("POLICY_THREAT_LIST_FILE"),
+	}
+	defaultEngine.modTime = map[string]time.Time{}
+	defaultEngine.reloadIfChanged()
+	interval := time.Second * time.Duration(utils.GetEnvInt("POLICY_RELOAD_SECONDS", RELOAD_INTERVAL_SECONDS))
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			defaultEngine.reloadIfChanged()
+		}
+	}()
+}
+
+// Check matches a destination against the current rules
+func Check(destination string) Verdict {
+	return defaultEngine.Check(destination)
+}
+
+func (e *Engine) reloadIfChanged() {
+	changed := false
+	for _, path := range e.files {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			utils.Log("error reading policy file " + path + ": " + err.Error())
+			continue
+		}
+		if !info.ModTime().Equal(e.modTime[path]) {
+			e.modTime[path] = info.ModTime()
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	rules, err := loadRuleset(e.files["domains"], e.files["patterns"], e.files["threats"])
+	if err != nil {
+		// keep serving the previous rules
+		utils.Log("error loading destination policy: " + err.Error())
+		return
+	}
+	e.mu.Lock()
+	e.rules = rules
+	e.mu.Unlock()
+	utils.Log("destination policy loaded")
+}
+
+// readLines returns the non-empty, non-comment lines of a file
+func readLines(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func loadRuleset(domainsFile string, patternsFile string, threatsFile string) (*ruleset, error) {
+	rules := &ruleset{
+		domains:        map[string]bool{},
+		threatPrefixes: map[int]map[string]bool{},
+	}
+	domains, err := readLines(domainsFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, domain := range domains {
+		rules.domains[strings.TrimSuffix(strings.ToLower(domain), ".")] = true
+	}
+	patterns, err := readLines(patternsFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range patterns {
+		source = strings.ToLower(source)
+		re, err := compileGlob(source)
+		if err != nil {
+			return nil, err
+		}
+		rules.patterns = append(rules.patterns, pattern{source: source, re: re, hasPath: strings.Contains(source, "/")})
+	}
+	prefixes, err := readLines(threatsFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, prefix := range prefixes {
+		prefix = strings.ToLower(prefix)
+		if _, err := hex.DecodeString(prefix); err != nil || len(prefix) < 8 || len(prefix) > 64 {
+			utils.Log("skipping invalid threat list prefix: " + prefix)
+			continue
+		}
+		if rules.threatPrefixes[len(prefix)] == nil {
+			rules.threatPrefixes[len(prefix)] = map[string]bool{}
+		}
+		rules.threatPrefixes[len(prefix)][prefix] = true
+	}
+	return rules, nil
+}
+
+// compileGlob turns a wildcard pattern into an anchored regexp, * matches any
+// run of characters and ? a single one
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	quoted := regexp.QuoteMeta(glob)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	return regexp.Compile("^" + quoted + "$")
+}
+
+func (e *Engine) Check(destination string) Verdict {
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Hostname() == "" {
+		return Verdict{}
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	// a blocked domain also blocks all of its subdomains
+	for candidate := host; candidate != ""; {
+		if rules.domains[candidate] {
+			return Verdict{Blocked: true, Reason: "blocked domain: " + candidate}
+		}
+		_, parent, found := strings.Cut(candidate, ".")
+		if !found {
+			break
+		}
+		candidate = parent
+	}
+	hostPath := host + parsed.EscapedPath()
+	for _, p := range rules.patterns {
+		target := host
+		if p.hasPath {
+			target = hostPath
+		}
+		if p.re.MatchString(target) {
+			return Verdict{Blocked: true, Reason: "blocked pattern: " + p.source}
+		}
+	}
+	if len(rules.threatPrefixes) > 0 {
+		for _, expression := range urlExpressions(host, parsed) {
+			sum := sha256.Sum256([]byte(expression))
+			digest := hex.EncodeToString(sum[:])
+			for length, prefixes := range rules.threatPrefixes {
+				if prefixes[digest[:length]] {
+					return Verdict{Blocked: true, Reason: "threat list: " + digest[:length]}
+				}
+			}
+		}
+	}
+	return Verdict{}
+}
+
+// urlExpressions returns the host suffix / path prefix combinations that are
+// hashed for threat list lookups, following the Safe Browsing scheme: the exact
+// host plus up to four trailing host components, combined with the exact path
+// with and without query and up to four leading path components.
+func urlExpressions(host string, parsed *url.URL) []string {
+	hosts := []string{host}
+	labels := strings.Split(host, ".")
+	for i := max(1, len(labels)-5); i < len(labels)-1; i++ {
+		hosts = append(hosts, strings.Join(labels[i:], "."))
+	}
+	path := parsed.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	paths := []string{}
+	if parsed.RawQuery != "" {
+		paths = append(paths, path+"?"+parsed.RawQuery)
+	}
+	paths = append(paths, path)
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	prefix := "/"
+	paths = append(paths, prefix)
+	for i := 0; i < len(segments)-1 && i < 3; i++ {
+		prefix += segments[i] + "/"
+		paths = append(paths, prefix)
+	}
+	expressions := []string{}
+	seen := map[string]bool{}
+	for _, h := range hosts {
+		for _, p := range paths {
+			if expression := h + p; !seen[expression] {
+				seen[expression] = true
+				expressions = append(expressions, expression)
+			}
+		}
+	}
+	return expressions
+}
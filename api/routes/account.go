@@ -0,0 +1,406 @@
+package routes
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var errSoleWorkspaceOwner = errors.New("transfer ownership of your shared workspaces before deleting your account")
+
+type Profile struct {
+	Id          string    `json:"id"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	Handle      string    `json:"handle"`
+	Role        string    `json:"role"`
+	Plan        string    `json:"plan"`
+	TotpEnabled bool      `json:"totpEnabled"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type UpdateProfileRequest struct {
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	Handle string `json:"handle"`
+	// required to change the email
+	CurrentPassword string `json:"currentPassword"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+func toProfile(user *models.User) Profile {
+	return Profile{
+		Id:          user.Id,
+		Name:        user.Name,
+		Email:       user.Email,
+		Handle:      user.HandleName(),
+		Role:        user.Role,
+		Plan:        config.GetPlan(user.Plan).Name,
+		TotpEnabled: user.TotpEnabled,
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+func GetProfile(c *fiber.Ctx) error {
+	user := &models.User{Id: c.Locals("userId").(string)}
+	if err := user.GetUserById(config.GetMySQLClient()); err != nil {
+		return userLookupError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Profile fetched successfully",
+		"success": true,
+		"data":    toProfile(user),
+	})
+}
+
+// UpdateProfile changes the name, email and handle. Changing the email needs the
+// current password and revokes every other session like ChangePassword.
+func UpdateProfile(c *fiber.Ctx) error {
+	req := new(UpdateProfileRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	user := &models.User{Id: c.Locals("userId").(string)}
+	tx := config.GetMySQLClient().Begin()
+	if err := user.GetUserById(tx); err != nil {
+		tx.Rollback()
+		return userLookupError(c, err)
+	}
+	oldHandle := user.HandleName()
+	before := fiber.Map{"name": user.Name, "email": user.Email, "handle": oldHandle}
+	updates := map[string]interface{}{}
+	if name := strings.TrimSpace(req.Name); name != "" {
+		updates["name"] = name
+	}
+	if email := strings.TrimSpace(req.Email); email != "" && email != user.Email {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"message": "Invalid credentials",
+				"success": false,
+				"error":   "Current password is incorrect",
+			})
+		}
+		// addresses are not confirmed, so bootstrap admin addresses cannot be
+		// claimed this way
+		if config.IsAdminEmail(email) {
+			tx.Rollback()
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Email not allowed",
+				"success": false,
+				"error":   "This email address cannot be used",
+			})
+		}
+		existing := &models.User{Email: email}
+		if err := existing.GetUserByEmail(tx); err == nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"message": "Email already in use",
+				"success": false,
+				"error":   "Email already in use",
+			})
+		}
+		updates["email"] = email
+		updates["token_version"] = user.TokenVersion + 1
+	}
+	// the handle names the user's namespace, /u/{handle}/{slug}
+	handle := strings.ToLower(strings.TrimSpace(req.Handle))
+	if handle != "" && handle != oldHandle {
+		if err := validateHandle(handle); err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid handle",
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+		existing := &models.User{Handle: &handle}
+		if err := existing.GetUserByHandle(tx); err == nil {
+			tx.Rollback()
+			return handleTakenResponse(c)
+		}
+		updates["handle"] = handle
+	}
+	renamed := []string{}
+	if len(updates) > 0 {
+		err := tx.Model(user).Updates(updates).Error
+		if err == nil && updates["handle"] != nil {
+			user.Handle = &handle
+			renamed, err = models.RenameNamespace(tx, user.Id, oldHandle, handle)
+		}
+		if err != nil {
+			tx.Rollback()
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return handleTakenResponse(c)
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Error updating profile",
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+	}
+	tx.Commit()
+	if len(renamed) > 0 {
+		purgeUrlCache(renamed...)
+	}
+	if len(updates) > 0 {
+		recordAudit(c, user.Id, models.AUDIT_USER_UPDATE, models.AUDIT_TARGET_USER, user.Id, before,
+			fiber.Map{"name": user.Name, "email": user.Email, "handle": user.HandleName()})
+	}
+	if tokenVersion, ok := updates["token_version"].(int); ok {
+		user.TokenVersion = tokenVersion
+		if err := setSessionCookie(c, user); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Error generating token",
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Profile updated successfully",
+		"success": true,
+		"data":    toProfile(user),
+	})
+}
+
+func handleTakenResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"message": "Handle already taken",
+		"success": false,
+		"error":   "Handle already taken",
+	})
+}
+
+// ChangePassword updates the password and revokes every other session by
+// bumping the token version, the current session gets a fresh cookie
+func ChangePassword(c *fiber.Ctx) error {
+	req := new(ChangePasswordRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if req.NewPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   "newPassword is required",
+		})
+	}
+	user := &models.User{Id: c.Locals("userId").(string)}
+	tx := config.GetMySQLClient().Begin()
+	if err := user.GetUserById(tx); err != nil {
+		tx.Rollback()
+		return userLookupError(c, err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Invalid credentials",
+			"success": false,
+			"error":   "Current password is incorrect",
+		})
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), 10)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error hashing password",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tokenVersion := user.TokenVersion + 1
+	err = tx.Model(user).Updates(map[string]interface{}{
+		"password":      string(hashedPassword),
+		"token_version": tokenVersion,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error updating password",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	recordAudit(c, user.Id, models.AUDIT_USER_PASSWORD, models.AUDIT_TARGET_USER, user.Id, nil, nil)
+	user.TokenVersion = tokenVersion
+	if err := setSessionCookie(c, user); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error generating token",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Password changed successfully",
+		"success": true,
+	})
+}
+
+func DeleteAccount(c *fiber.Ctx) error {
+	return removeAccount(c, false)
+}
+
+// EraseAccount deletes the account like DeleteAccount and also erases the
+// audit events about the user, leaving no personal data behind
+func EraseAccount(c *fiber.Ctx) error {
+	return removeAccount(c, true)
+}
+
+func removeAccount(c *fiber.Ctx, erase bool) error {
+	req := new(DeleteAccountRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	user := &models.User{Id: c.Locals("userId").(string)}
+	tx := config.GetMySQLClient().Begin()
+	if err := user.GetUserById(tx); err != nil {
+		tx.Rollback()
+		return userLookupError(c, err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Invalid credentials",
+			"success": false,
+			"error":   "Password is incorrect",
+		})
+	}
+	shorts, err := deleteAccountData(tx, user)
+	if err == nil && erase {
+		err = models.DeleteAuditEventsByUserId(tx, user.Id)
+	}
+	if err != nil {
+		tx.Rollback()
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, errSoleWorkspaceOwner) {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"message": "Error deleting account",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error deleting account",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	purgeUrlCache(shorts...)
+	reloadCustomDomains()
+	if !erase {
+		recordAudit(c, user.Id, models.AUDIT_USER_DELETE, models.AUDIT_TARGET_USER, user.Id, fiber.Map{
+			"name":  user.Name,
+			"email": user.Email,
+			"links": shorts,
+		}, nil)
+	}
+	clearSessionCookie(c)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Account deleted successfully",
+		"success": true,
+	})
+}
+
+// deleteAccountData permanently removes the user with their personal urls,
+// clicks, recovery codes and memberships. Workspaces where the user is the only
+// member are deleted with their links, shared workspaces keep their links.
+// Returns the short codes that have to be purged from the cache.
+func deleteAccountData(tx *gorm.DB, user *models.User) ([]string, error) {
+	urls, err := models.GetPersonalUrlsByUserId(tx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := models.GetMembershipsByUserId(tx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, membership := range memberships {
+		members, err := models.CountWorkspaceMembers(tx, membership.WorkspaceId)
+		if err != nil {
+			return nil, err
+		}
+		if members == 1 {
+			workspaceUrls, err := models.GetUrlsByWorkspaceId(tx, membership.WorkspaceId)
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, workspaceUrls...)
+			workspace := &models.Workspace{Id: membership.WorkspaceId}
+			if err := workspace.DeleteWorkspace(tx); err != nil {
+				return nil, err
+			}
+			if err := models.DeleteUsageByScopeId(tx, workspace.Id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if membership.Role == models.WORKSPACE_ROLE_OWNER {
+			owners, err := models.CountWorkspaceOwners(tx, membership.WorkspaceId)
+			if err != nil {
+				return nil, err
+			}
+			if owners <= 1 {
+				return nil, errSoleWorkspaceOwner
+			}
+		}
+		if err := membership.DeleteMember(tx); err != nil {
+			return nil, err
+		}
+	}
+	ids := make([]string, len(urls))
+	for i, url := range urls {
+		ids[i] = url.Id
+	}
+	shorts, err := models.GetAliasKeysByUrlIds(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if err := models.DeleteUrlsWithClicks(tx, urls); err != nil {
+		return nil, err
+	}
+	if err := models.DeleteRecoveryCodesByUserId(tx, user.Id); err != nil {
+		return nil, err
+	}
+	if err := models.DeleteDomainsByUserId(tx, user.Id); err != nil {
+		return nil, err
+	}
+	if err := models.DeleteUsageByScopeId(tx, user.Id); err != nil {
+		return nil, err
+	}
+	if err := user.DeleteUser(tx); err != nil {
+		return nil, err
+	}
+	return shorts, nil
+}
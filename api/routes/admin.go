@@ -0,0 +1,300 @@
+package routes
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"gorm.io/gorm"
+)
+
+const (
+	ADMIN_DEFAULT_PAGE_SIZE = 20
+	ADMIN_MAX_PAGE_SIZE     = 100
+)
+
+type UserStatusRequest struct {
+	Disabled bool `json:"disabled"`
+}
+
+type UserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type TakedownRequest struct {
+	Reason string `json:"reason"`
+}
+
+type AdminUser struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Role        string `json:"role"`
+	Plan        string `json:"plan"`
+	Disabled    bool   `json:"disabled"`
+	TotpEnabled bool   `json:"totpEnabled"`
+}
+
+func toAdminUser(user models.User) AdminUser {
+	return AdminUser{
+		Id:          user.Id,
+		Name:        user.Name,
+		Email:       user.Email,
+		Role:        user.Role,
+		Plan:        config.GetPlan(user.Plan).Name,
+		Disabled:    user.Disabled,
+		TotpEnabled: user.TotpEnabled,
+	}
+}
+
+// paginate applies page and limit query params to a query
+func paginate(c *fiber.Ctx, query *gorm.DB) *gorm.DB {
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+	limit := c.QueryInt("limit", ADMIN_DEFAULT_PAGE_SIZE)
+	if limit < 1 || limit > ADMIN_MAX_PAGE_SIZE {
+		limit = ADMIN_DEFAULT_PAGE_SIZE
+	}
+	return query.Offset((page - 1) * limit).Limit(limit)
+}
+
+func AdminListUsers(c *fiber.Ctx) error {
+	tx := config.GetMySQLClient()
+	query := tx.Model(&models.User{})
+	if q := c.Query("q"); q != "" {
+		query = query.Where("name LIKE ? OR email LIKE ?", "%"+q+"%", "%"+q+"%")
+	}
+	if role := c.Query("role"); role != "" {
+		query = query.Where("role = ?", role)
+	}
+	if disabled := c.Query("disabled"); disabled != "" {
+		query = query.Where("disabled = ?", disabled == "true")
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting users",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	users := []models.User{}
+	if err := paginate(c, query.Order("created_at DESC")).Find(&users).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting users",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	result := make([]AdminUser, len(users))
+	for i, user := range users {
+		result[i] = toAdminUser(user)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Users fetched successfully",
+		"success": true,
+		"data":    result,
+		"total":   total,
+	})
+}
+
+// AdminSetUserStatus disables or enables an account. Disabling revokes sessions
+// through authMiddleware and stops the user's links from resolving.
+func AdminSetUserStatus(c *fiber.Ctx) error {
+	req := new(UserStatusRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	user := &models.User{Id: c.Params("id")}
+	if user.Id == c.Locals("userId").(string) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Cannot change your own status",
+			"success": false,
+			"error":   "Cannot change your own status",
+		})
+	}
+	tx := config.GetMySQLClient().Begin()
+	if err := user.GetUserById(tx); err != nil {
+		tx.Rollback()
+		return userLookupError(c, err)
+	}
+	wasDisabled := user.Disabled
+	if err := tx.Model(user).Update("disabled", req.Disabled).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error updating user",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	shorts, err := models.GetUrlKeysByUserId(tx, user.Id)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error updating user",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	purgeUrlCache(shorts...)
+	recordAudit(c, currentUserId(c), models.AUDIT_USER_STATUS, models.AUDIT_TARGET_USER, user.Id,
+		fiber.Map{"disabled": wasDisabled}, fiber.Map{"disabled": req.Disabled})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User updated successfully",
+		"success": true,
+		"data":    toAdminUser(*user),
+	})
+}
+
+func AdminSetUserRole(c *fiber.Ctx) error {
+	req := new(UserRoleRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if !models.IsValidRole(req.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid role",
+			"success": false,
+			"error":   "role must be one of: " + models.ROLE_USER + ", " + models.ROLE_ADMIN,
+		})
+	}
+	user := &models.User{Id: c.Params("id")}
+	if user.Id == c.Locals("userId").(string) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Cannot change your own role",
+			"success": false,
+			"error":   "Cannot change your own role",
+		})
+	}
+	tx := config.GetMySQLClient().Begin()
+	if err := user.GetUserById(tx); err != nil {
+		tx.Rollback()
+		return userLookupError(c, err)
+	}
+	previousRole := user.Role
+	if err := tx.Model(user).Update("role", req.Role).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error updating user",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	recordAudit(c, currentUserId(c), models.AUDIT_USER_ROLE, models.AUDIT_TARGET_USER, user.Id,
+		fiber.Map{"role": previousRole}, fiber.Map{"role": req.Role})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User updated successfully",
+		"success": true,
+		"data":    toAdminUser(*user),
+	})
+}
+
+func AdminListUrls(c *fiber.Ctx) error {
+	tx := config.GetMySQLClient()
+	query := tx.Model(&models.Url{})
+	if q := c.Query("q"); q != "" {
+		query = query.Where("short LIKE ? OR `long` LIKE ?", "%"+q+"%", "%"+q+"%")
+	}
+	if userId := c.Query("userId"); userId != "" {
+		query = query.Where("user_id = ?", userId)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if c.Query("flagged") == "true" {
+		query = query.Where("policy_match <> ''")
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting urls",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	urls := []models.Url{}
+	if err := paginate(c, query.Order("created_at DESC")).Find(&urls).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting urls",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Urls fetched successfully",
+		"success": true,
+		"data":    urls,
+		"total":   total,
+	})
+}
+
+func AdminTakedownUrl(c *fiber.Ctx) error {
+	req := new(TakedownRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	return setUrlStatus(c, models.AUDIT_URL_TAKEDOWN, models.URL_STATUS_TAKEN_DOWN, req.Reason)
+}
+
+func AdminRestoreUrl(c *fiber.Ctx) error {
+	return setUrlStatus(c, models.AUDIT_URL_RESTORE, models.URL_STATUS_ACTIVE, "")
+}
+
+func setUrlStatus(c *fiber.Ctx, action string, status string, reason string) error {
+	url := urlFromParams(c)
+	tx := config.GetMySQLClient().Begin()
+	if err := url.GetUrlByShort(tx); err != nil {
+		tx.Rollback()
+		return urlLookupError(c, err)
+	}
+	before := auditUrl(url)
+	err := tx.Model(url).Updates(map[string]interface{}{"status": status, "takedown_reason": reason}).Error
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error updating url",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	purgeAliasCache(config.GetMySQLClient(), url)
+	recordAudit(c, currentUserId(c), action, models.AUDIT_TARGET_URL, url.Id, before, auditUrl(url))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Url updated successfully",
+		"success": true,
+		"data":    url,
+	})
+}
+
+func userLookupError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "User not found",
+			"success": false,
+			"error":   "User not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"message": "Error getting user",
+		"success": false,
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,305 @@
+package routes
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+	"gorm.io/gorm"
+)
+
+type AliasRequest struct {
+	// empty generates a code
+	Alias string `json:"alias,omitempty"`
+	// create the alias under the user's handle
+	Namespaced bool `json:"namespaced,omitempty"`
+	// verified custom domain of the user the alias is served on
+	Domain string `json:"domain,omitempty"`
+}
+
+type AliasStats struct {
+	models.Alias
+	Clicks int64 `json:"clicks"`
+}
+
+type UrlStats struct {
+	UrlId   string       `json:"urlId"`
+	Clicks  int64        `json:"clicks"`
+	Aliases []AliasStats `json:"aliases"`
+	// clicks through removed aliases or recorded before aliases were tracked
+	OtherClicks int64 `json:"otherClicks"`
+}
+
+// scopedUrl loads the :id url when it belongs to the selected workspace, or is
+// a personal url of the user, and the user has at least minRole in the workspace
+func scopedUrl(c *fiber.Ctx, tx *gorm.DB, minRole string) (*models.Url, error) {
+	workspaceId, err := workspaceScope(c, tx, minRole)
+	if err != nil {
+		return nil, err
+	}
+	url := &models.Url{Id: c.Params("id")}
+	if err := url.GetScopedUrl(tx, c.Locals("userId").(string), workspaceId); err != nil {
+		return nil, err
+	}
+	return url, nil
+}
+
+// scopedUrlError maps scopedUrl errors to a response
+func scopedUrlError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errWorkspaceNotMember) || errors.Is(err, errWorkspaceForbidden) {
+		return workspaceError(c, err)
+	}
+	return urlLookupError(c, err)
+}
+
+// urlAlias loads the :aliasId alias of the url
+func urlAlias(c *fiber.Ctx, tx *gorm.DB, url *models.Url) (*models.Alias, error) {
+	alias := &models.Alias{}
+	err := tx.Where("id = ? AND url_id = ?", c.Params("aliasId"), url.Id).First(alias).Error
+	return alias, err
+}
+
+func aliasLookupError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Alias not found",
+			"success": false,
+			"error":   "Alias not found",
+		})
+	}
+	return aliasError(c, "Error getting alias", err)
+}
+
+func GetUrlAliases(c *fiber.Ctx) error {
+	tx := config.GetMySQLClient()
+	url, err := scopedUrl(c, tx, models.WORKSPACE_ROLE_VIEWER)
+	if err != nil {
+		return scopedUrlError(c, err)
+	}
+	aliases, err := models.GetAliasesByUrlId(tx, url.Id)
+	if err != nil {
+		return aliasError(c, "Error getting aliases", err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Aliases fetched successfully",
+		"success": true,
+		"data":    aliases,
+	})
+}
+
+// AddUrlAlias adds a custom or generated alias to a url, custom aliases count
+// against the plan like links created with one
+func AddUrlAlias(c *fiber.Ctx) error {
+	req := new(AliasRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if req.Namespaced && req.Alias == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid custom short code",
+			"success": false,
+			"error":   "alias is required for namespaced aliases",
+		})
+	}
+	if err := validateCustomShort(req.Alias); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid custom short code",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	userId := c.Locals("userId").(string)
+	tx := beginQuotaTx()
+	url, err := scopedUrl(c, tx, models.WORKSPACE_ROLE_EDITOR)
+	if err != nil {
+		tx.Rollback()
+		return scopedUrlError(c, err)
+	}
+	user := &models.User{Id: userId}
+	if err := user.GetUserById(tx); err != nil {
+		tx.Rollback()
+		return userLookupError(c, err)
+	}
+	domain, err := linkDomain(tx, userId, req.Domain)
+	if err != nil {
+		tx.Rollback()
+		return linkDomainError(c, err)
+	}
+	// namespaced aliases live under the handle of the link's creator
+	namespace := ""
+	if req.Namespaced {
+		if url.WorkspaceId != "" {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid namespace",
+				"success": false,
+				"error":   "Workspace links cannot be namespaced",
+			})
+		}
+		if namespace = user.HandleName(); namespace == "" {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid namespace",
+				"success": false,
+				"error":   errNoHandle.Error(),
+			})
+		}
+	}
+	if req.Alias != "" {
+		if err := checkAliasLimit(tx, url.UserId, url.WorkspaceId); err != nil {
+			tx.Rollback()
+			return quotaErrorResponse(c, err)
+		}
+	}
+	alias := &models.Alias{UrlId: url.Id, Domain: domain, Namespace: namespace, Short: req.Alias, Custom: req.Alias != ""}
+	if err := insertAlias(tx, alias); err != nil {
+		tx.Rollback()
+		if errors.Is(err, errShortTaken) {
+			message := "The custom short code you requested is already in use"
+			if namespace != "" {
+				message += " in your namespace"
+			}
+			suggestions, err := suggestAliases(config.GetMySQLClient(), req.Alias, domain, namespace, suggestionHandle(user, namespace))
+			if err != nil {
+				utils.Log("error suggesting aliases: " + err.Error())
+				suggestions = []string{}
+			}
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"message":     "Custom short code is already taken",
+				"success":     false,
+				"error":       message,
+				"suggestions": suggestions,
+			})
+		}
+		return aliasError(c, "Error adding alias", err)
+	}
+	tx.Commit()
+	recordAudit(c, userId, models.AUDIT_ALIAS_ADD, models.AUDIT_TARGET_URL, url.Id, nil, auditAlias(alias))
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Alias added successfully",
+		"success": true,
+		"data":    alias,
+	})
+}
+
+// DeleteUrlAlias removes an alias from a url, the primary alias has to be
+// replaced first
+func DeleteUrlAlias(c *fiber.Ctx) error {
+	tx := config.GetMySQLClient().Begin()
+	url, err := scopedUrl(c, tx, models.WORKSPACE_ROLE_EDITOR)
+	if err != nil {
+		tx.Rollback()
+		return scopedUrlError(c, err)
+	}
+	alias, err := urlAlias(c, tx, url)
+	if err != nil {
+		tx.Rollback()
+		return aliasLookupError(c, err)
+	}
+	if alias.Primary {
+		tx.Rollback()
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"message": "Cannot remove the primary alias",
+			"success": false,
+			"error":   "Make another alias primary before removing this one",
+		})
+	}
+	if err := alias.DeleteAlias(tx); err != nil {
+		tx.Rollback()
+		return aliasError(c, "Error removing alias", err)
+	}
+	tx.Commit()
+	purgeUrlCache(alias.Key())
+	recordAudit(c, currentUserId(c), models.AUDIT_ALIAS_REMOVE, models.AUDIT_TARGET_URL, url.Id, auditAlias(alias), nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Alias removed successfully",
+		"success": true,
+	})
+}
+
+// SetPrimaryUrlAlias makes an alias the primary alias of its url, the code
+// shown for the link
+func SetPrimaryUrlAlias(c *fiber.Ctx) error {
+	tx := config.GetMySQLClient().Begin()
+	url, err := scopedUrl(c, tx, models.WORKSPACE_ROLE_EDITOR)
+	if err != nil {
+		tx.Rollback()
+		return scopedUrlError(c, err)
+	}
+	alias, err := urlAlias(c, tx, url)
+	if err != nil {
+		tx.Rollback()
+		return aliasLookupError(c, err)
+	}
+	before := auditUrl(url)
+	if err := models.SetPrimaryAlias(tx, alias); err != nil {
+		tx.Rollback()
+		return aliasError(c, "Error updating alias", err)
+	}
+	tx.Commit()
+	url.Domain, url.Namespace, url.Short, url.Custom = alias.Domain, alias.Namespace, alias.Short, alias.Custom
+	// cached entries carry the primary code
+	purgeAliasCache(config.GetMySQLClient(), url)
+	recordAudit(c, currentUserId(c), models.AUDIT_ALIAS_PRIMARY, models.AUDIT_TARGET_URL, url.Id, before, auditUrl(url))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Primary alias updated successfully",
+		"success": true,
+		"data":    alias,
+	})
+}
+
+// GetUrlStats reports the clicks of a url in total and per alias
+func GetUrlStats(c *fiber.Ctx) error {
+	tx := config.GetMySQLClient()
+	url, err := scopedUrl(c, tx, models.WORKSPACE_ROLE_VIEWER)
+	if err != nil {
+		return scopedUrlError(c, err)
+	}
+	aliases, err := models.GetAliasesByUrlId(tx, url.Id)
+	if err != nil {
+		return aliasError(c, "Error getting stats", err)
+	}
+	counts, err := models.GetClickCountsByAlias(tx, url.Id)
+	if err != nil {
+		return aliasError(c, "Error getting stats", err)
+	}
+	stats := UrlStats{UrlId: url.Id, Aliases: make([]AliasStats, len(aliases))}
+	for _, count := range counts {
+		stats.Clicks += count
+	}
+	stats.OtherClicks = stats.Clicks
+	for i, alias := range aliases {
+		stats.Aliases[i] = AliasStats{Alias: alias, Clicks: counts[alias.Id]}
+		stats.OtherClicks -= counts[alias.Id]
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Stats fetched successfully",
+		"success": true,
+		"data":    stats,
+	})
+}
+
+// auditAlias is the state of an alias recorded in audit events
+func auditAlias(alias *models.Alias) fiber.Map {
+	return fiber.Map{
+		"aliasId":   alias.Id,
+		"domain":    alias.Domain,
+		"namespace": alias.Namespace,
+		"short":     alias.Short,
+		"custom":    alias.Custom,
+	}
+}
+
+func aliasError(c *fiber.Ctx, message string, err error) error {
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"message": message,
+		"success": false,
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,128 @@
+package routes
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+)
+
+// currentUserId returns the authenticated user, empty on public routes
+func currentUserId(c *fiber.Ctx) string {
+	userId, _ := c.Locals("userId").(string)
+	return userId
+}
+
+// auditValue encodes a before or after value, nil is stored as empty
+func auditValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+// recordAudit appends an audit event for a change that was committed. Failures
+// are logged and never fail the request.
+func recordAudit(c *fiber.Ctx, actorId string, action string, targetType string, targetId string, before interface{}, after interface{}) {
+	event := &models.AuditEvent{
+		ActorId:    actorId,
+		ActorIp:    utils.PrivacyIP(c.IP()),
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   targetId,
+		Before:     auditValue(before),
+		After:      auditValue(after),
+	}
+	if err := event.CreateAuditEvent(config.GetMySQLClient()); err != nil {
+		utils.Log("error recording audit event " + action + ": " + err.Error())
+	}
+}
+
+// auditUrl is the state of a url recorded in audit events
+func auditUrl(url *models.Url) fiber.Map {
+	return fiber.Map{
+		"domain":         url.Domain,
+		"namespace":      url.Namespace,
+		"short":          url.Short,
+		"long":           url.Long,
+		"userId":         url.UserId,
+		"workspaceId":    url.WorkspaceId,
+		"expiry":         url.Expiry,
+		"status":         url.Status,
+		"takedownReason": url.TakedownReason,
+	}
+}
+
+// parseAuditTime accepts RFC 3339 timestamps or plain dates
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// GetAuditEvents lists audit events filtered by actorId, action, targetType,
+// targetId and a from/to time range. Admins see every event, other users only
+// the events they caused.
+func GetAuditEvents(c *fiber.Ctx) error {
+	from, err := parseAuditTime(c.Query("from"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid from time",
+			"success": false,
+			"error":   "from must be an RFC 3339 timestamp or a date",
+		})
+	}
+	to, err := parseAuditTime(c.Query("to"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid to time",
+			"success": false,
+			"error":   "to must be an RFC 3339 timestamp or a date",
+		})
+	}
+	filter := models.AuditFilter{
+		ActorId:    c.Query("actorId"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("targetType"),
+		TargetId:   c.Query("targetId"),
+		From:       from,
+		To:         to,
+	}
+	if c.Locals("role") != models.ROLE_ADMIN {
+		filter.ActorId = currentUserId(c)
+	}
+	tx := config.GetMySQLClient()
+	var total int64
+	if err := models.QueryAuditEvents(tx, filter).Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting audit events",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	events := []models.AuditEvent{}
+	if err := paginate(c, models.QueryAuditEvents(tx, filter)).Find(&events).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting audit events",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Audit events fetched successfully",
+		"success": true,
+		"data":    events,
+		"total":   total,
+	})
+}
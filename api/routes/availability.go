@@ -0,0 +1,219 @@
+package routes
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+	"gorm.io/gorm"
+)
+
+const SUGGESTION_LIMIT = 5
+
+// reasons an alias is unavailable
+const (
+	ALIAS_INVALID  = "invalid"
+	ALIAS_RESERVED = "reserved"
+	ALIAS_BANNED   = "banned"
+	ALIAS_TAKEN    = "taken"
+)
+
+// suffixes tried after the alias, closest alternatives first
+var suggestionSuffixes = []string{"hq", "now", "go", "app", "link"}
+
+type Availability struct {
+	Alias       string   `json:"alias"`
+	Available   bool     `json:"available"`
+	Reason      string   `json:"reason,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// aliasProblem returns why an alias cannot be used as a custom short code,
+// empty when the rules allow it
+func aliasProblem(alias string) (string, error) {
+	err := validateCustomShort(alias)
+	if err == nil {
+		return "", nil
+	}
+	if reservedMatch(alias) != "" {
+		return ALIAS_RESERVED, err
+	}
+	if utils.ContainsBannedWord(alias) {
+		return ALIAS_BANNED, err
+	}
+	return ALIAS_INVALID, err
+}
+
+// aliasWords splits an alias at separators and other characters that are not
+// allowed, so "Spring Sale!" becomes spring and sale
+func aliasWords(alias string) []string {
+	return strings.FieldsFunc(strings.ToLower(alias), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+}
+
+// dropVowels abbreviates a word by removing vowels after its first letter
+func dropVowels(word string) string {
+	var abbreviated strings.Builder
+	for i, r := range word {
+		if i > 0 && strings.ContainsRune("aeiou", r) {
+			continue
+		}
+		abbreviated.WriteRune(r)
+	}
+	return abbreviated.String()
+}
+
+// suggestionCandidates returns alternatives to alias ranked by how close they
+// stay to it: separator variants, abbreviations, then suffixes
+func suggestionCandidates(alias string, handle string) []string {
+	words := aliasWords(alias)
+	if len(words) == 0 {
+		return nil
+	}
+	separators := slugSeparators()
+	joined := strings.Join(words, "")
+	candidates := []string{}
+	// separator variants
+	for _, separator := range separators {
+		candidates = append(candidates, strings.Join(words, string(separator)))
+	}
+	candidates = append(candidates, joined)
+	// abbreviations
+	if len(words) > 1 {
+		initials := ""
+		for _, word := range words[:len(words)-1] {
+			initials += word[:1]
+		}
+		candidates = append(candidates, initials+words[len(words)-1])
+	}
+	candidates = append(candidates, dropVowels(joined))
+	// suffixes, joined with the first allowed separator
+	join := ""
+	if separators != "" {
+		join = separators[:1]
+	}
+	if handle != "" {
+		candidates = append(candidates, handle+join+joined)
+	}
+	for _, suffix := range suggestionSuffixes {
+		candidates = append(candidates, joined+join+suffix)
+	}
+	candidates = append(candidates, joined+join+strconv.Itoa(time.Now().Year()))
+	for n := 2; n <= 9; n++ {
+		candidates = append(candidates, joined+strconv.Itoa(n))
+	}
+	return candidates
+}
+
+// suggestionHandle is the handle offered as a prefix for global aliases,
+// namespaced aliases already live under it
+func suggestionHandle(user *models.User, namespace string) string {
+	if namespace != "" {
+		return ""
+	}
+	return user.HandleName()
+}
+
+// suggestAliases returns up to SUGGESTION_LIMIT free aliases close to alias in
+// the namespace on the domain, empty for global aliases on DOMAIN
+func suggestAliases(tx *gorm.DB, alias string, domain string, namespace string, handle string) ([]string, error) {
+	seen := map[string]bool{models.FoldShort(alias): true}
+	allowed := []string{}
+	for _, candidate := range suggestionCandidates(alias, handle) {
+		if len(candidate) > MAX_CUSTOM_LENGTH {
+			candidate = strings.TrimRight(candidate[:MAX_CUSTOM_LENGTH], SLUG_SEPARATORS)
+		}
+		if seen[models.FoldShort(candidate)] {
+			continue
+		}
+		seen[models.FoldShort(candidate)] = true
+		if problem, _ := aliasProblem(candidate); problem == "" {
+			allowed = append(allowed, candidate)
+		}
+	}
+	taken, err := models.GetTakenShorts(tx, domain, namespace, allowed)
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	for _, short := range taken {
+		used[models.FoldShort(short)] = true
+	}
+	suggestions := []string{}
+	for _, candidate := range allowed {
+		if !used[models.FoldShort(candidate)] && len(suggestions) < SUGGESTION_LIMIT {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	return suggestions, nil
+}
+
+// CheckAliasAvailability reports whether ?alias= can be used as a custom short
+// code, globally or with ?namespaced=true under the user's handle, on DOMAIN or
+// a verified ?domain=, and suggests free alternatives when it cannot
+func CheckAliasAvailability(c *fiber.Ctx) error {
+	alias := strings.TrimSpace(c.Query("alias"))
+	if alias == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Alias is required",
+			"success": false,
+			"error":   "Alias is required",
+		})
+	}
+	tx := config.GetMySQLClient()
+	user := &models.User{Id: c.Locals("userId").(string)}
+	if err := user.GetUserById(tx); err != nil {
+		return userLookupError(c, err)
+	}
+	domain, err := linkDomain(tx, user.Id, c.Query("domain"))
+	if err != nil {
+		return linkDomainError(c, err)
+	}
+	namespace := ""
+	if c.QueryBool("namespaced") {
+		if namespace = user.HandleName(); namespace == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid namespace",
+				"success": false,
+				"error":   errNoHandle.Error(),
+			})
+		}
+	}
+	result := Availability{Alias: alias, Available: true, Suggestions: []string{}}
+	problem, err := aliasProblem(alias)
+	if problem != "" {
+		result.Available, result.Reason, result.Error = false, problem, err.Error()
+	} else {
+		taken, err := models.GetTakenShorts(tx, domain, namespace, []string{alias})
+		if err != nil {
+			return availabilityError(c, err)
+		}
+		if len(taken) > 0 {
+			result.Available, result.Reason, result.Error = false, ALIAS_TAKEN, "The custom short code is already in use"
+		}
+	}
+	if !result.Available {
+		if result.Suggestions, err = suggestAliases(tx, alias, domain, namespace, suggestionHandle(user, namespace)); err != nil {
+			return availabilityError(c, err)
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Availability checked successfully",
+		"success": true,
+		"data":    result,
+	})
+}
+
+func availabilityError(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"message": "Error checking availability",
+		"success": false,
+		"error":   err.Error(),
+	})
+}
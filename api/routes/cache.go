@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"gorm.io/gorm"
+)
+
+const URL_CACHE_TTL = time.Minute * 30
+
+type CacheUrl struct {
+	Id string `json:"id"`
+	// alias the entry is cached under
+	AliasId     string    `json:"aliasId"`
+	Long        string    `json:"long"`
+	Domain      string    `json:"domain"`
+	Namespace   string    `json:"namespace"`
+	Short       string    `json:"short"`
+	Expiry      time.Time `json:"expiry"`
+	Status      string    `json:"status"`
+	PolicyMatch string    `json:"policyMatch"`
+}
+
+// urlCacheKey is the url key folded like database lookups, so every spelling
+// of a code shares one cache entry in case-insensitive mode
+func urlCacheKey(key string) string {
+	return models.FoldShort(key)
+}
+
+// getCachedUrl loads the url an alias resolves to from redis into url
+func getCachedUrl(alias *models.Alias, url *models.Url) error {
+	r, err := config.GetRedisClient(0).Get(config.RedisCtx, urlCacheKey(alias.Key())).Result()
+	if err != nil {
+		return err
+	}
+	cachedUrl := new(CacheUrl)
+	if err := json.Unmarshal([]byte(r), cachedUrl); err != nil {
+		return err
+	}
+	url.Expiry = cachedUrl.Expiry
+	url.Id = cachedUrl.Id
+	url.Long = cachedUrl.Long
+	url.Domain = cachedUrl.Domain
+	url.Namespace = cachedUrl.Namespace
+	url.Short = cachedUrl.Short
+	url.Status = cachedUrl.Status
+	url.PolicyMatch = cachedUrl.PolicyMatch
+	alias.Id = cachedUrl.AliasId
+	return nil
+}
+
+func setCachedUrl(alias *models.Alias, url *models.Url) {
+	cacheUrl := &CacheUrl{
+		Id:          url.Id,
+		AliasId:     alias.Id,
+		Long:        url.Long,
+		Domain:      url.Domain,
+		Namespace:   url.Namespace,
+		Short:       url.Short,
+		Expiry:      url.Expiry,
+		Status:      url.Status,
+		PolicyMatch: url.PolicyMatch,
+	}
+	jsonData, err := json.Marshal(cacheUrl)
+	if err != nil {
+		fmt.Println("error marshalling url", err)
+		return
+	}
+	err = config.GetRedisClient(0).Set(config.RedisCtx, urlCacheKey(alias.Key()), string(jsonData), URL_CACHE_TTL).Err()
+	if err != nil {
+		fmt.Println("error setting cache", err)
+	}
+}
+
+// purgeUrlCache removes urls from the resolver cache by their keys
+func purgeUrlCache(urlKeys ...string) {
+	if len(urlKeys) == 0 {
+		return
+	}
+	keys := make([]string, len(urlKeys))
+	for i, key := range urlKeys {
+		keys[i] = urlCacheKey(key)
+	}
+	if err := config.GetRedisClient(0).Del(config.RedisCtx, keys...).Err(); err != nil {
+		fmt.Println("error purging cache", err)
+	}
+}
+
+// purgeAliasCache removes every alias of the url from the resolver cache, the
+// primary alias when the aliases cannot be loaded
+func purgeAliasCache(tx *gorm.DB, url *models.Url) {
+	keys, err := models.GetAliasKeysByUrlIds(tx, []string{url.Id})
+	if err != nil {
+		fmt.Println("error loading aliases", err)
+		keys = []string{url.Key()}
+	}
+	purgeUrlCache(keys...)
+}
@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/middleware"
+)
+
+// GetCSRFToken returns the token cookie authenticated clients must send in
+// the X-CSRF-Token header of state changing requests
+func GetCSRFToken(c *fiber.Ctx) error {
+	token, err := middleware.EnsureCSRFToken(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error generating csrf token",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Csrf token generated successfully",
+		"success": true,
+		"data": fiber.Map{
+			"token":  token,
+			"header": middleware.CSRF_HEADER,
+		},
+	})
+}
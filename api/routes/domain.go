@@ -0,0 +1,332 @@
+package routes
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/domains"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	CUSTOM_DOMAINS_CACHE_TTL = time.Minute
+	// random bytes of a verification token
+	DOMAIN_TOKEN_BYTES = 16
+)
+
+var errDomainNotVerified = errors.New("domain must be a verified domain of yours, add and verify it first")
+
+// customDomains caches the verified hosts links are resolved on
+var customDomains struct {
+	mu       sync.Mutex
+	hosts    map[string]bool
+	loadedAt time.Time
+}
+
+type DomainRequest struct {
+	Host string `json:"host"`
+}
+
+type VerifyDomainRequest struct {
+	// dns or http
+	Method string `json:"method"`
+}
+
+// DomainVerification tells the user how to prove ownership of a domain
+type DomainVerification struct {
+	TxtRecordName  string `json:"txtRecordName"`
+	TxtRecordValue string `json:"txtRecordValue"`
+	HttpUrl        string `json:"httpUrl"`
+	HttpBody       string `json:"httpBody"`
+}
+
+type DomainInfo struct {
+	models.Domain
+	Verified     bool               `json:"verified"`
+	Verification DomainVerification `json:"verification"`
+}
+
+func toDomainInfo(domain *models.Domain) DomainInfo {
+	return DomainInfo{
+		Domain:   *domain,
+		Verified: domain.IsVerified(),
+		Verification: DomainVerification{
+			TxtRecordName:  domains.TXTRecordName(domain.Host),
+			TxtRecordValue: domains.TXTRecordValue(domain.Token),
+			HttpUrl:        domains.WellKnownUrl(domain.Host),
+			HttpBody:       domain.Token,
+		},
+	}
+}
+
+// isCustomDomain reports whether host is a verified domain, the list is
+// reloaded every CUSTOM_DOMAINS_CACHE_TTL so other instances pick up changes
+func isCustomDomain(host string) bool {
+	customDomains.mu.Lock()
+	defer customDomains.mu.Unlock()
+	if time.Since(customDomains.loadedAt) > CUSTOM_DOMAINS_CACHE_TTL {
+		hosts, err := models.GetVerifiedHosts(config.GetMySQLClient())
+		if err != nil {
+			// keep the previous list until the database is back
+			utils.Log("error loading custom domains: " + err.Error())
+			return customDomains.hosts[host]
+		}
+		customDomains.hosts = make(map[string]bool, len(hosts))
+		for _, verified := range hosts {
+			customDomains.hosts[verified] = true
+		}
+		customDomains.loadedAt = time.Now()
+	}
+	return customDomains.hosts[host]
+}
+
+// reloadCustomDomains makes the next check load the verified domains again
+func reloadCustomDomains() {
+	customDomains.mu.Lock()
+	customDomains.loadedAt = time.Time{}
+	customDomains.mu.Unlock()
+}
+
+// requestDomain returns the custom domain a request was sent to, empty for
+// DOMAIN and any host that is not a verified domain
+func requestDomain(c *fiber.Ctx) string {
+	host := strings.ToLower(c.Hostname())
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || host == utils.ServiceHostname() || !isCustomDomain(host) {
+		return ""
+	}
+	return host
+}
+
+// linkDomain returns the normalized host links of the user are created on,
+// empty for DOMAIN. The domain has to be verified by the user.
+func linkDomain(tx *gorm.DB, userId string, host string) (string, error) {
+	if strings.TrimSpace(host) == "" {
+		return "", nil
+	}
+	host, err := domains.NormalizeHost(host)
+	if err != nil {
+		return "", err
+	}
+	domain := &models.Domain{Host: host}
+	if err := domain.GetVerifiedDomain(tx); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", errDomainNotVerified
+		}
+		return "", err
+	}
+	if domain.UserId != userId {
+		return "", errDomainNotVerified
+	}
+	return host, nil
+}
+
+// linkDomainError maps linkDomain errors to a response
+func linkDomainError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errDomainNotVerified) || errors.Is(err, domains.ErrInvalidHost) || errors.Is(err, domains.ErrServiceHost) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid domain",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	return domainError(c, "Error checking domain", err)
+}
+
+func GetDomains(c *fiber.Ctx) error {
+	list, err := models.GetDomainsByUserId(config.GetMySQLClient(), c.Locals("userId").(string))
+	if err != nil {
+		return domainError(c, "Error getting domains", err)
+	}
+	infos := make([]DomainInfo, len(list))
+	for i := range list {
+		infos[i] = toDomainInfo(&list[i])
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Domains fetched successfully",
+		"success": true,
+		"data":    infos,
+	})
+}
+
+// AddDomain registers a domain of the user, links can use it once verified
+func AddDomain(c *fiber.Ctx) error {
+	req := new(DomainRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	host, err := domains.NormalizeHost(req.Host)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid domain",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	userId := c.Locals("userId").(string)
+	tx := config.GetMySQLClient().Begin()
+	existing, err := models.GetDomainsByUserId(tx, userId)
+	if err != nil {
+		tx.Rollback()
+		return domainError(c, "Error adding domain", err)
+	}
+	for _, domain := range existing {
+		if domain.Host == host {
+			tx.Rollback()
+			return domainTakenResponse(c, "You already added this domain")
+		}
+	}
+	verified := &models.Domain{Host: host}
+	if err := verified.GetVerifiedDomain(tx); err == nil {
+		tx.Rollback()
+		return domainTakenResponse(c, "Domain is already verified by another user")
+	}
+	token, err := utils.GenerateRandomToken(DOMAIN_TOKEN_BYTES)
+	if err != nil {
+		tx.Rollback()
+		return domainError(c, "Error adding domain", err)
+	}
+	domain := &models.Domain{UserId: userId, Host: host, Token: token}
+	if err := domain.CreateDomain(tx); err != nil {
+		tx.Rollback()
+		return domainError(c, "Error adding domain", err)
+	}
+	tx.Commit()
+	recordAudit(c, userId, models.AUDIT_DOMAIN_ADD, models.AUDIT_TARGET_DOMAIN, domain.Id, nil, fiber.Map{"host": host})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Domain added, publish the verification token and verify it",
+		"success": true,
+		"data":    toDomainInfo(domain),
+	})
+}
+
+// VerifyDomain checks the TXT record or well-known token of a domain
+func VerifyDomain(c *fiber.Ctx) error {
+	req := new(VerifyDomainRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if req.Method != domains.METHOD_DNS && req.Method != domains.METHOD_HTTP {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid verification method",
+			"success": false,
+			"error":   domains.ErrUnknownMethod.Error(),
+		})
+	}
+	userId := c.Locals("userId").(string)
+	tx := config.GetMySQLClient()
+	domain := &models.Domain{Id: c.Params("id"), UserId: userId}
+	if err := domain.GetUserDomain(tx); err != nil {
+		return domainLookupError(c, err)
+	}
+	if domain.IsVerified() {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message": "Domain is already verified",
+			"success": true,
+			"data":    toDomainInfo(domain),
+		})
+	}
+	if err := domains.Verify(c.Context(), req.Method, domain.Host, domain.Token); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"message": "Domain verification failed",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if err := domain.MarkVerified(tx); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return domainTakenResponse(c, "Domain is already verified by another user")
+		}
+		return domainError(c, "Error verifying domain", err)
+	}
+	reloadCustomDomains()
+	recordAudit(c, userId, models.AUDIT_DOMAIN_VERIFY, models.AUDIT_TARGET_DOMAIN, domain.Id, nil,
+		fiber.Map{"host": domain.Host, "method": req.Method})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Domain verified successfully",
+		"success": true,
+		"data":    toDomainInfo(domain),
+	})
+}
+
+// DeleteDomain removes a domain that no link uses anymore
+func DeleteDomain(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	tx := config.GetMySQLClient().Begin()
+	domain := &models.Domain{Id: c.Params("id"), UserId: userId}
+	if err := domain.GetUserDomain(tx); err != nil {
+		tx.Rollback()
+		return domainLookupError(c, err)
+	}
+	if domain.IsVerified() {
+		links, err := models.CountAliasesByDomain(tx, domain.Host)
+		if err != nil {
+			tx.Rollback()
+			return domainError(c, "Error deleting domain", err)
+		}
+		if links > 0 {
+			tx.Rollback()
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"message": "Domain is in use",
+				"success": false,
+				"error":   "Delete the links on this domain first",
+			})
+		}
+	}
+	if err := domain.DeleteDomain(tx); err != nil {
+		tx.Rollback()
+		return domainError(c, "Error deleting domain", err)
+	}
+	tx.Commit()
+	reloadCustomDomains()
+	recordAudit(c, userId, models.AUDIT_DOMAIN_REMOVE, models.AUDIT_TARGET_DOMAIN, domain.Id, fiber.Map{"host": domain.Host}, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Domain deleted successfully",
+		"success": true,
+	})
+}
+
+func domainLookupError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Domain not found",
+			"success": false,
+			"error":   "Domain not found",
+		})
+	}
+	return domainError(c, "Error getting domain", err)
+}
+
+func domainTakenResponse(c *fiber.Ctx, message string) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"message": "Domain already taken",
+		"success": false,
+		"error":   message,
+	})
+}
+
+func domainError(c *fiber.Ctx, message string, err error) error {
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"message": message,
+		"success": false,
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+)
+
+// GetJWKS publishes the public keys that verify our tokens, following RFC 7517
+func GetJWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(fiber.StatusOK).JSON(utils.JWKS())
+}
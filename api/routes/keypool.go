@@ -0,0 +1,157 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/shortcode"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+)
+
+const (
+	KEY_POOL_SIZE       = 1000
+	KEY_POOL_REFILL_AT  = 250
+	KEY_POOL_BATCH_SIZE = 200
+	KEY_POOL_INTERVAL   = time.Second * 10
+)
+
+// keyPoolLow wakes the pool worker when a pop left the pool below its refill
+// threshold, buffered so ShortenUrl never waits on it
+var keyPoolLow = make(chan struct{}, 1)
+
+// keyPoolKey is the redis set of unused codes of one length, so a longer
+// code length starts a fresh pool
+func keyPoolKey(length int) string {
+	return "shortcode:pool:" + strconv.Itoa(length)
+}
+
+func keyPoolSize() int {
+	return utils.GetEnvInt("SHORTCODE_POOL_SIZE", KEY_POOL_SIZE)
+}
+
+// StartKeyPool keeps a redis set of unused generated codes between
+// SHORTCODE_POOL_REFILL_AT and SHORTCODE_POOL_SIZE codes, 0 disables the pool.
+// The set lives in redis, so it survives restarts and is shared between
+// instances. Codes are popped with SPOP, which hands every code out once.
+func StartKeyPool() {
+	size := keyPoolSize()
+	if size <= 0 {
+		return
+	}
+	refillAt := utils.GetEnvInt("SHORTCODE_POOL_REFILL_AT", KEY_POOL_REFILL_AT)
+	interval := time.Second * time.Duration(utils.GetEnvInt("SHORTCODE_POOL_CHECK_SECONDS", int(KEY_POOL_INTERVAL/time.Second)))
+	go func() {
+		lastLength := 0
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			length, err := shortCodeLength(config.GetMySQLClient())
+			if err != nil {
+				utils.Log("error refilling short code pool: " + err.Error())
+			} else {
+				// codes of the old length are no longer handed out
+				if lastLength != 0 && lastLength != length {
+					config.GetRedisClient(0).Del(config.RedisCtx, keyPoolKey(lastLength))
+				}
+				lastLength = length
+				if err := refillKeyPool(length, size, refillAt); err != nil {
+					utils.Log("error refilling short code pool: " + err.Error())
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-keyPoolLow:
+			}
+		}
+	}()
+}
+
+// refillKeyPool tops the pool up to size once it holds fewer than refillAt codes
+func refillKeyPool(length int, size int, refillAt int) error {
+	rdb := config.GetRedisClient(0)
+	key := keyPoolKey(length)
+	pooled, err := rdb.SCard(config.RedisCtx, key).Result()
+	if err != nil {
+		return err
+	}
+	if pooled >= int64(refillAt) {
+		return nil
+	}
+	for missing := size - int(pooled); missing > 0; {
+		batch, err := keyPoolBatch(length, min(missing, KEY_POOL_BATCH_SIZE))
+		if err != nil {
+			return err
+		}
+		// a crowded keyspace, try again on the next round
+		if len(batch) == 0 {
+			return nil
+		}
+		members := make([]interface{}, len(batch))
+		for i, code := range batch {
+			members[i] = code
+		}
+		added, err := rdb.SAdd(config.RedisCtx, key, members...).Result()
+		if err != nil {
+			return err
+		}
+		if added == 0 {
+			return nil
+		}
+		missing -= int(added)
+	}
+	return nil
+}
+
+// keyPoolBatch generates up to count codes that are neither banned nor in use
+func keyPoolBatch(length int, count int) ([]string, error) {
+	candidates := []string{}
+	for range count {
+		code, err := shortcode.Default().Generate(length)
+		if err != nil {
+			return nil, err
+		}
+		if !utils.ContainsBannedWord(code) {
+			candidates = append(candidates, code)
+		}
+	}
+	taken, err := models.GetTakenShorts(config.GetMySQLClient(), "", "", candidates)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool, len(taken))
+	for _, code := range taken {
+		used[code] = true
+	}
+	batch := []string{}
+	for _, code := range candidates {
+		if !used[code] {
+			batch = append(batch, code)
+		}
+	}
+	return batch, nil
+}
+
+// popPooledCode takes an unused code of the given length from the pool, false
+// when the pool is disabled, empty or unavailable
+func popPooledCode(length int) (string, bool) {
+	if keyPoolSize() <= 0 {
+		return "", false
+	}
+	code, err := config.GetRedisClient(0).SPop(config.RedisCtx, keyPoolKey(length)).Result()
+	// wake the worker, it refills once the pool is below the threshold
+	select {
+	case keyPoolLow <- struct{}{}:
+	default:
+	}
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			utils.Log("error popping short code from pool: " + err.Error())
+		}
+		return "", false
+	}
+	return code, true
+}
@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	LOGIN_MAX_ACCOUNT_ATTEMPTS = 5
+	LOGIN_MAX_IP_ATTEMPTS      = 20
+	LOGIN_LOCKOUT_BASE_SECONDS = 60
+	LOGIN_LOCKOUT_MAX_SECONDS  = 3600
+)
+
+var (
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+type loginThrottleScope struct {
+	name        string
+	id          string
+	maxAttempts int
+}
+
+func loginThrottleScopes(email string, ip string) []loginThrottleScope {
+	return []loginThrottleScope{
+		{name: "account", id: strings.ToLower(strings.TrimSpace(email)), maxAttempts: utils.GetEnvInt("LOGIN_MAX_ACCOUNT_ATTEMPTS", LOGIN_MAX_ACCOUNT_ATTEMPTS)},
+		{name: "ip", id: ip, maxAttempts: utils.GetEnvInt("LOGIN_MAX_IP_ATTEMPTS", LOGIN_MAX_IP_ATTEMPTS)},
+	}
+}
+
+func (scope loginThrottleScope) failuresKey() string {
+	return "login:fail:" + scope.name + ":" + scope.id
+}
+
+func (scope loginThrottleScope) lockKey() string {
+	return "login:lock:" + scope.name + ":" + scope.id
+}
+
+// loginLockedFor returns how long the email or ip is still locked out, zero when not locked
+func loginLockedFor(email string, ip string) time.Duration {
+	var longest time.Duration
+	for _, scope := range loginThrottleScopes(email, ip) {
+		ttl, err := config.GetRedisClient(0).TTL(config.RedisCtx, scope.lockKey()).Result()
+		if err == nil && ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest
+}
+
+// recordLoginFailure counts a failed login for the email and ip. Once a counter
+// reaches its limit every further failure locks it for an exponentially growing
+// period, capped at LOGIN_LOCKOUT_MAX_SECONDS.
+func recordLoginFailure(email string, ip string) {
+	rdb := config.GetRedisClient(0)
+	maxLockout := time.Second * time.Duration(utils.GetEnvInt("LOGIN_LOCKOUT_MAX_SECONDS", LOGIN_LOCKOUT_MAX_SECONDS))
+	baseLockout := time.Second * time.Duration(utils.GetEnvInt("LOGIN_LOCKOUT_BASE_SECONDS", LOGIN_LOCKOUT_BASE_SECONDS))
+	for _, scope := range loginThrottleScopes(email, ip) {
+		failures, err := rdb.Incr(config.RedisCtx, scope.failuresKey()).Result()
+		if err != nil {
+			utils.Log("error recording login failure: " + err.Error())
+			continue
+		}
+		// failures are forgotten once no attempt was made for the max lockout period
+		rdb.Expire(config.RedisCtx, scope.failuresKey(), maxLockout*2)
+		excess := int(failures) - scope.maxAttempts
+		if excess < 0 {
+			continue
+		}
+		lockout := baseLockout
+		for i := 0; i < excess && lockout < maxLockout; i++ {
+			lockout *= 2
+		}
+		lockout = min(lockout, maxLockout)
+		if err := rdb.Set(config.RedisCtx, scope.lockKey(), failures, lockout).Err(); err != nil {
+			utils.Log("error setting login lockout: " + err.Error())
+			continue
+		}
+		utils.LogSecurityEvent("login_lockout", map[string]string{
+			"scope":    scope.name,
+			"target":   scope.id,
+			"ip":       ip,
+			"failures": strconv.FormatInt(failures, 10),
+			"lockout":  lockout.String(),
+		})
+	}
+}
+
+// resetLoginFailures clears the account counter after a successful login
+func resetLoginFailures(email string) {
+	scope := loginThrottleScopes(email, "")[0]
+	config.GetRedisClient(0).Del(config.RedisCtx, scope.failuresKey(), scope.lockKey())
+}
+
+// compareDummyPassword spends the same time as a real password check so unknown
+// emails cannot be told apart by response time
+func compareDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), 10)
+	})
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+)
+
+const (
+	NAMESPACE_PREFIX  = "u"
+	MIN_HANDLE_LENGTH = 3
+	MAX_HANDLE_LENGTH = 32
+)
+
+var errNoHandle = errors.New("claim a handle on your profile before creating namespaced links")
+
+// NamespacePrefix is the first path segment of namespaced links, set with
+// NAMESPACE_PREFIX
+func NamespacePrefix() string {
+	if prefix := strings.Trim(os.Getenv("NAMESPACE_PREFIX"), "/"); prefix != "" {
+		return prefix
+	}
+	return NAMESPACE_PREFIX
+}
+
+// urlFromParams addresses a link by the :short param, an optional ?namespace=
+// handle for namespaced links and ?domain= for links on a custom domain
+func urlFromParams(c *fiber.Ctx) *models.Url {
+	return &models.Url{
+		Domain:    strings.ToLower(c.Query("domain")),
+		Namespace: strings.ToLower(c.Query("namespace")),
+		Short:     c.Params("short"),
+	}
+}
+
+// validateHandle checks a handle, handles are stored lowercase
+func validateHandle(handle string) error {
+	if len(handle) < MIN_HANDLE_LENGTH {
+		return fmt.Errorf("handle must be at least %d characters", MIN_HANDLE_LENGTH)
+	}
+	if len(handle) > MAX_HANDLE_LENGTH {
+		return fmt.Errorf("handle must be at most %d characters", MAX_HANDLE_LENGTH)
+	}
+	separators := slugSeparators()
+	matched, err := matchesSlug(handle, "a-z0-9", separators)
+	if err != nil {
+		return errors.New("error validating handle")
+	}
+	if !matched {
+		if separators == "" {
+			return errors.New("handle must contain only lowercase letters and digits")
+		}
+		return fmt.Errorf("handle must contain only lowercase letters, digits and %s, not at the start or end and not doubled", strings.Join(strings.Split(separators, ""), " "))
+	}
+	if reservedMatch(handle) != "" {
+		return fmt.Errorf("'%s' is a reserved word and cannot be used", handle)
+	}
+	if utils.ContainsBannedWord(handle) {
+		return errors.New("handle contains a word that is not allowed")
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"gorm.io/gorm"
+)
+
+// error codes returned when a plan limit is reached
+const (
+	QUOTA_ACTIVE_LINKS    = "active_link_limit"
+	QUOTA_CUSTOM_ALIASES  = "custom_alias_limit"
+	QUOTA_LINKS_PER_MONTH = "monthly_link_limit"
+	QUOTA_EXPIRY          = "expiry_exceeds_plan"
+)
+
+type PlanRequest struct {
+	Plan string `json:"plan"`
+}
+
+// QuotaError describes a plan limit a request would exceed
+type QuotaError struct {
+	Status  int
+	Code    string
+	Message string
+	Limit   int
+	Used    int64
+}
+
+func (e *QuotaError) Error() string {
+	return e.Message
+}
+
+// UsageLimit reports the consumption of one plan limit, a limit of 0 is unlimited
+type UsageLimit struct {
+	Limit int   `json:"limit"`
+	Used  int64 `json:"used"`
+}
+
+type Usage struct {
+	Plan           string     `json:"plan"`
+	WorkspaceId    string     `json:"workspaceId,omitempty"`
+	ActiveLinks    UsageLimit `json:"activeLinks"`
+	CustomAliases  UsageLimit `json:"customAliases"`
+	LinksThisMonth UsageLimit `json:"linksThisMonth"`
+	MaxExpiryDays  int        `json:"maxExpiryDays"`
+}
+
+// scopePlan returns the plan of the workspace, or of the user for personal links
+func scopePlan(tx *gorm.DB, userId string, workspaceId string) (config.Plan, error) {
+	if workspaceId != "" {
+		workspace := &models.Workspace{Id: workspaceId}
+		if err := workspace.GetWorkspaceById(tx); err != nil {
+			return config.Plan{}, err
+		}
+		return config.GetPlan(workspace.Plan), nil
+	}
+	user := &models.User{Id: userId}
+	if err := user.GetUserById(tx); err != nil {
+		return config.Plan{}, err
+	}
+	return config.GetPlan(user.Plan), nil
+}
+
+// usageScopeId is the id monthly usage is counted against
+func usageScopeId(userId string, workspaceId string) string {
+	if workspaceId != "" {
+		return workspaceId
+	}
+	return userId
+}
+
+func getUsage(tx *gorm.DB, userId string, workspaceId string) (*Usage, error) {
+	plan, err := scopePlan(tx, userId, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	active, err := models.CountActiveUrls(tx, userId, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	custom, err := models.CountCustomAliases(tx, userId, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	monthly, err := models.GetLinksCreatedThisMonth(tx, usageScopeId(userId, workspaceId))
+	if err != nil {
+		return nil, err
+	}
+	return &Usage{
+		Plan:           plan.Name,
+		WorkspaceId:    workspaceId,
+		ActiveLinks:    UsageLimit{Limit: plan.MaxActiveLinks, Used: active},
+		CustomAliases:  UsageLimit{Limit: plan.MaxCustomAliases, Used: custom},
+		LinksThisMonth: UsageLimit{Limit: plan.MaxLinksPerMonth, Used: monthly},
+		MaxExpiryDays:  plan.MaxExpiryDays,
+	}, nil
+}
+
+// beginQuotaTx starts a transaction for changes checked against plan limits.
+// It reads committed data so counts taken after models.LockScope include the
+// links of requests that held the lock before.
+func beginQuotaTx() *gorm.DB {
+	return config.GetMySQLClient().Begin(&sql.TxOptions{Isolation: sql.LevelReadCommitted})
+}
+
+// exceeded reports whether one more item would go over the limit
+func (limit UsageLimit) exceeded() bool {
+	return limit.Limit > 0 && limit.Used >= int64(limit.Limit)
+}
+
+// checkPlanLimits returns a *QuotaError when creating a link would exceed the
+// plan of the scope. A zero expiry is replaced by the default expiry, capped
+// at the plan's maximum. tx has to come from beginQuotaTx, the scope stays
+// locked until it ends.
+func checkPlanLimits(tx *gorm.DB, userId string, workspaceId string, custom bool, expiry *time.Time) error {
+	if err := models.LockScope(tx, userId, workspaceId); err != nil {
+		return err
+	}
+	usage, err := getUsage(tx, userId, workspaceId)
+	if err != nil {
+		return err
+	}
+	if usage.ActiveLinks.exceeded() {
+		return &QuotaError{
+			Status:  fiber.StatusForbidden,
+			Code:    QUOTA_ACTIVE_LINKS,
+			Message: "Your plan allows " + strconv.Itoa(usage.ActiveLinks.Limit) + " active links, delete or let some expire first",
+			Limit:   usage.ActiveLinks.Limit,
+			Used:    usage.ActiveLinks.Used,
+		}
+	}
+	if custom && usage.CustomAliases.exceeded() {
+		return customAliasQuotaError(usage)
+	}
+	if usage.LinksThisMonth.exceeded() {
+		return &QuotaError{
+			Status:  fiber.StatusTooManyRequests,
+			Code:    QUOTA_LINKS_PER_MONTH,
+			Message: "Your plan allows " + strconv.Itoa(usage.LinksThisMonth.Limit) + " new links per month",
+			Limit:   usage.LinksThisMonth.Limit,
+			Used:    usage.LinksThisMonth.Used,
+		}
+	}
+	if usage.MaxExpiryDays > 0 {
+		maxExpiry := time.Now().Add(time.Hour * 24 * time.Duration(usage.MaxExpiryDays))
+		if expiry.IsZero() {
+			if defaultExpiry := time.Now().Add(time.Hour * 24 * 30); defaultExpiry.After(maxExpiry) {
+				*expiry = maxExpiry
+			}
+		} else if expiry.After(maxExpiry) {
+			return &QuotaError{
+				Status:  fiber.StatusForbidden,
+				Code:    QUOTA_EXPIRY,
+				Message: "Your plan allows links to expire at most " + strconv.Itoa(usage.MaxExpiryDays) + " days from now",
+				Limit:   usage.MaxExpiryDays,
+			}
+		}
+	}
+	return nil
+}
+
+// checkAliasLimit returns a *QuotaError when adding a custom alias to a link
+// would exceed the plan of the scope, locking it like checkPlanLimits
+func checkAliasLimit(tx *gorm.DB, userId string, workspaceId string) error {
+	if err := models.LockScope(tx, userId, workspaceId); err != nil {
+		return err
+	}
+	usage, err := getUsage(tx, userId, workspaceId)
+	if err != nil {
+		return err
+	}
+	if usage.CustomAliases.exceeded() {
+		return customAliasQuotaError(usage)
+	}
+	return nil
+}
+
+func customAliasQuotaError(usage *Usage) *QuotaError {
+	return &QuotaError{
+		Status:  fiber.StatusForbidden,
+		Code:    QUOTA_CUSTOM_ALIASES,
+		Message: "Your plan allows " + strconv.Itoa(usage.CustomAliases.Limit) + " custom aliases",
+		Limit:   usage.CustomAliases.Limit,
+		Used:    usage.CustomAliases.Used,
+	}
+}
+
+// nextMonthStart returns the time the monthly link quota resets
+func nextMonthStart() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func quotaErrorResponse(c *fiber.Ctx, err error) error {
+	var quotaErr *QuotaError
+	if !errors.As(err, &quotaErr) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error checking plan limits",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if quotaErr.Code == QUOTA_LINKS_PER_MONTH {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(time.Until(nextMonthStart()).Seconds())+1))
+	}
+	return c.Status(quotaErr.Status).JSON(fiber.Map{
+		"message": "Plan limit reached",
+		"success": false,
+		"error":   quotaErr.Message,
+		"code":    quotaErr.Code,
+		"limit":   quotaErr.Limit,
+		"used":    quotaErr.Used,
+	})
+}
+
+// GetUsage reports the consumption of the personal or selected workspace plan
+func GetUsage(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	tx := config.GetMySQLClient()
+	workspaceId, err := workspaceScope(c, tx, models.WORKSPACE_ROLE_VIEWER)
+	if err != nil {
+		return workspaceError(c, err)
+	}
+	usage, err := getUsage(tx, userId, workspaceId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting usage",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Usage fetched successfully",
+		"success": true,
+		"data":    usage,
+	})
+}
+
+func invalidPlanResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"message": "Invalid plan",
+		"success": false,
+		"error":   "plan must be one of: " + strings.Join(config.PlanNames(), ", "),
+	})
+}
+
+func AdminSetUserPlan(c *fiber.Ctx) error {
+	req := new(PlanRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if !config.IsValidPlan(req.Plan) {
+		return invalidPlanResponse(c)
+	}
+	user := &models.User{Id: c.Params("id")}
+	tx := config.GetMySQLClient().Begin()
+	if err := user.GetUserById(tx); err != nil {
+		tx.Rollback()
+		return userLookupError(c, err)
+	}
+	previousPlan := config.GetPlan(user.Plan).Name
+	if err := tx.Model(user).Update("plan", req.Plan).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error updating user",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	recordAudit(c, currentUserId(c), models.AUDIT_USER_PLAN, models.AUDIT_TARGET_USER, user.Id,
+		fiber.Map{"plan": previousPlan}, fiber.Map{"plan": req.Plan})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User updated successfully",
+		"success": true,
+		"data":    toAdminUser(*user),
+	})
+}
+
+func AdminSetWorkspacePlan(c *fiber.Ctx) error {
+	req := new(PlanRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if !config.IsValidPlan(req.Plan) {
+		return invalidPlanResponse(c)
+	}
+	workspace := &models.Workspace{Id: c.Params("id")}
+	tx := config.GetMySQLClient().Begin()
+	if err := workspace.GetWorkspaceById(tx); err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"message": "Workspace not found",
+				"success": false,
+				"error":   "Workspace not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting workspace",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	previousPlan := config.GetPlan(workspace.Plan).Name
+	if err := tx.Model(workspace).Update("plan", req.Plan).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error updating workspace",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	recordAudit(c, currentUserId(c), models.AUDIT_WORKSPACE_PLAN, models.AUDIT_TARGET_WORKSPACE, workspace.Id,
+		fiber.Map{"plan": previousPlan}, fiber.Map{"plan": req.Plan})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Workspace updated successfully",
+		"success": true,
+		"data":    workspace,
+	})
+}
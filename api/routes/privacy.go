@@ -0,0 +1,120 @@
+package routes
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+	"gorm.io/gorm"
+)
+
+const RETENTION_PURGE_INTERVAL = time.Hour
+
+// ExportClick is a click in a data export, visitor ips belong to the visitors
+// and are left out
+type ExportClick struct {
+	UrlId     string    `json:"urlId"`
+	AliasId   string    `json:"aliasId,omitempty"`
+	ClickedAt time.Time `json:"clickedAt"`
+}
+
+type AccountExport struct {
+	ExportedAt  time.Time                `json:"exportedAt"`
+	Profile     Profile                  `json:"profile"`
+	Urls        []models.Url             `json:"urls"`
+	Aliases     []models.Alias           `json:"aliases"`
+	Domains     []models.Domain          `json:"domains"`
+	Clicks      []ExportClick            `json:"clicks"`
+	Workspaces  []models.WorkspaceMember `json:"workspaces"`
+	AuditEvents []models.AuditEvent      `json:"auditEvents"`
+}
+
+// StartRetentionPurge clears the visitor ip of clicks, the actor ip of audit
+// events and the reporter ip of closed abuse reports older than
+// CLICK_RETENTION_DAYS every hour, 0 keeps them forever
+func StartRetentionPurge() {
+	days := utils.GetEnvInt("CLICK_RETENTION_DAYS", 0)
+	if days <= 0 {
+		return
+	}
+	purge := func() {
+		before := time.Now().Add(-time.Hour * 24 * time.Duration(days))
+		purges := []struct {
+			name  string
+			purge func(tx *gorm.DB, before time.Time) (int64, error)
+		}{
+			{"clicks", models.AnonymizeClicksBefore},
+			{"audit events", models.AnonymizeAuditEventsBefore},
+			{"abuse reports", models.AnonymizeClosedReportsBefore},
+		}
+		for _, p := range purges {
+			purged, err := p.purge(config.GetMySQLClient(), before)
+			if err != nil {
+				utils.Log("error purging ips of " + p.name + ": " + err.Error())
+				continue
+			}
+			if purged > 0 {
+				utils.Log("purged ips of " + strconv.FormatInt(purged, 10) + " " + p.name)
+			}
+		}
+	}
+	go func() {
+		purge()
+		for range time.Tick(RETENTION_PURGE_INTERVAL) {
+			purge()
+		}
+	}()
+}
+
+// ExportAccountData returns everything stored about the user as a JSON download
+func ExportAccountData(c *fiber.Ctx) error {
+	user := &models.User{Id: c.Locals("userId").(string)}
+	tx := config.GetMySQLClient()
+	if err := user.GetUserById(tx); err != nil {
+		return userLookupError(c, err)
+	}
+	export := AccountExport{ExportedAt: time.Now().UTC(), Profile: toProfile(user), Clicks: []ExportClick{}}
+	urls, err := models.GetUrlsByUserId(tx, user.Id)
+	if err != nil {
+		return exportError(c, err)
+	}
+	export.Urls = urls
+	urlIds := make([]string, len(urls))
+	for i, url := range urls {
+		urlIds[i] = url.Id
+	}
+	if export.Aliases, err = models.GetAliasesByUrlIds(tx, urlIds); err != nil {
+		return exportError(c, err)
+	}
+	if export.Domains, err = models.GetDomainsByUserId(tx, user.Id); err != nil {
+		return exportError(c, err)
+	}
+	clicks, err := models.GetClicksByUrlIds(tx, urlIds)
+	if err != nil {
+		return exportError(c, err)
+	}
+	for _, click := range clicks {
+		export.Clicks = append(export.Clicks, ExportClick{UrlId: click.UrlId, AliasId: click.AliasId, ClickedAt: click.CreatedAt})
+	}
+	if export.Workspaces, err = models.GetMembershipsByUserId(tx, user.Id); err != nil {
+		return exportError(c, err)
+	}
+	export.AuditEvents = []models.AuditEvent{}
+	if err := models.QueryAuditEvents(tx, models.AuditFilter{ActorId: user.Id}).Find(&export.AuditEvents).Error; err != nil {
+		return exportError(c, err)
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Attachment("ziplink-export-" + export.ExportedAt.Format(time.DateOnly) + ".json")
+	return c.Status(fiber.StatusOK).JSON(export)
+}
+
+func exportError(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"message": "Error exporting data",
+		"success": false,
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,329 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+	"gorm.io/gorm"
+)
+
+// number of open reports that suspends a link pending review
+const REPORT_SUSPEND_THRESHOLD = 5
+
+type ReportUrlRequest struct {
+	Short string `json:"short"`
+	// handle of a namespaced link, empty for global links
+	Namespace string `json:"namespace"`
+	// custom domain of the link, empty for DOMAIN
+	Domain   string `json:"domain"`
+	Category string `json:"category"`
+	Reason   string `json:"reason"`
+}
+
+type ReportQueueItem struct {
+	models.ReportSummary
+	Url        models.Url       `json:"url"`
+	Categories map[string]int64 `json:"categories"`
+}
+
+// ReportUrl lets anyone report a short link. Repeated reports from the same ip
+// are accepted but not counted again.
+func ReportUrl(c *fiber.Ctx) error {
+	req := new(ReportUrlRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	req.Short = strings.TrimSpace(req.Short)
+	if req.Short == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Short url is required",
+			"success": false,
+			"error":   "Short url is required",
+		})
+	}
+	req.Category = strings.ToLower(strings.TrimSpace(req.Category))
+	if !models.IsValidReportCategory(req.Category) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid category",
+			"success": false,
+			"error": "category must be one of: " + strings.Join([]string{models.REPORT_CATEGORY_PHISHING,
+				models.REPORT_CATEGORY_MALWARE, models.REPORT_CATEGORY_SPAM, models.REPORT_CATEGORY_OTHER}, ", "),
+		})
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.Reason) > models.REPORT_REASON_MAX_LENGTH {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid reason",
+			"success": false,
+			"error":   "reason must be at most " + strconv.Itoa(models.REPORT_REASON_MAX_LENGTH) + " characters",
+		})
+	}
+	url := &models.Url{
+		Domain:    strings.ToLower(strings.TrimSpace(req.Domain)),
+		Namespace: strings.ToLower(strings.TrimSpace(req.Namespace)),
+		Short:     req.Short,
+	}
+	tx := config.GetMySQLClient().Begin()
+	if err := url.GetUrlByShort(tx); err != nil {
+		tx.Rollback()
+		return urlLookupError(c, err)
+	}
+	report := &models.AbuseReport{
+		UrlId:      url.Id,
+		ReporterIp: utils.PrivacyIP(c.IP()),
+		Category:   req.Category,
+		Reason:     req.Reason,
+	}
+	created, err := report.CreateReport(tx)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error reporting url",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	suspended := false
+	if created && url.Status == models.URL_STATUS_ACTIVE {
+		reports, err := models.CountOpenReportsByUrlId(tx, url.Id)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Error reporting url",
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+		if reports >= int64(utils.GetEnvInt("ABUSE_REPORT_THRESHOLD", REPORT_SUSPEND_THRESHOLD)) {
+			reason := fmt.Sprintf("Suspended pending review after %d abuse reports", reports)
+			err := tx.Model(url).Updates(map[string]interface{}{"status": models.URL_STATUS_SUSPENDED, "takedown_reason": reason}).Error
+			if err != nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"message": "Error reporting url",
+					"success": false,
+					"error":   err.Error(),
+				})
+			}
+			suspended = true
+		}
+	}
+	tx.Commit()
+	if suspended {
+		purgeAliasCache(config.GetMySQLClient(), url)
+		recordAudit(c, "", models.AUDIT_URL_SUSPEND, models.AUDIT_TARGET_URL, url.Id,
+			fiber.Map{"status": models.URL_STATUS_ACTIVE}, fiber.Map{"status": url.Status, "takedownReason": url.TakedownReason})
+		utils.LogSecurityEvent("url_suspended", map[string]string{"short": url.Short, "urlId": url.Id})
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Report received",
+		"success": true,
+	})
+}
+
+// AdminListReports returns reported links aggregated per link, open reports by default
+func AdminListReports(c *fiber.Ctx) error {
+	status := c.Query("status", models.REPORT_STATUS_OPEN)
+	tx := config.GetMySQLClient()
+	var total int64
+	if err := tx.Table("(?) AS queue", models.ReportQueue(tx, status)).Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting reports",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	summaries := []models.ReportSummary{}
+	if err := paginate(c, models.ReportQueue(tx, status)).Scan(&summaries).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting reports",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	queue := []ReportQueueItem{}
+	for _, summary := range summaries {
+		url := models.Url{}
+		if err := tx.Where("id = ?", summary.UrlId).First(&url).Error; err != nil {
+			// the link was deleted since it was reported
+			continue
+		}
+		categories, err := models.CountReportsByCategory(tx, summary.UrlId, status)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Error getting reports",
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+		queue = append(queue, ReportQueueItem{ReportSummary: summary, Url: url, Categories: categories})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Reports fetched successfully",
+		"success": true,
+		"data":    queue,
+		"total":   total,
+	})
+}
+
+// AdminGetUrlReports returns every report of a link
+func AdminGetUrlReports(c *fiber.Ctx) error {
+	url := urlFromParams(c)
+	tx := config.GetMySQLClient()
+	if err := url.GetUrlByShort(tx); err != nil {
+		return urlLookupError(c, err)
+	}
+	reports, err := models.GetReportsByUrlId(tx, url.Id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting reports",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Reports fetched successfully",
+		"success": true,
+		"data":    fiber.Map{"url": url, "reports": reports},
+	})
+}
+
+// AdminTakedownReportedUrl takes the link down and resolves its open reports
+func AdminTakedownReportedUrl(c *fiber.Ctx) error {
+	req := new(TakedownRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	return resolveReports(c, models.REPORT_STATUS_RESOLVED, models.URL_STATUS_TAKEN_DOWN, req.Reason, false)
+}
+
+// AdminDismissReports dismisses the open reports and reactivates a suspended link
+func AdminDismissReports(c *fiber.Ctx) error {
+	return resolveReports(c, models.REPORT_STATUS_DISMISSED, models.URL_STATUS_ACTIVE, "", false)
+}
+
+// AdminBanReportedOwner disables the owner of the link, takes the link down
+// and resolves its open reports
+func AdminBanReportedOwner(c *fiber.Ctx) error {
+	req := new(TakedownRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	return resolveReports(c, models.REPORT_STATUS_RESOLVED, models.URL_STATUS_TAKEN_DOWN, req.Reason, true)
+}
+
+func resolveReports(c *fiber.Ctx, reportStatus string, urlStatus string, reason string, banOwner bool) error {
+	url := urlFromParams(c)
+	tx := config.GetMySQLClient().Begin()
+	if err := url.GetUrlByShort(tx); err != nil {
+		tx.Rollback()
+		return urlLookupError(c, err)
+	}
+	if banOwner && url.UserId == c.Locals("userId").(string) {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Cannot change your own status",
+			"success": false,
+			"error":   "Cannot change your own status",
+		})
+	}
+	before := auditUrl(url)
+	// dismissing only reactivates links that were suspended by reports
+	if urlStatus != models.URL_STATUS_ACTIVE || url.Status == models.URL_STATUS_SUSPENDED {
+		err := tx.Model(url).Updates(map[string]interface{}{"status": urlStatus, "takedown_reason": reason}).Error
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Error updating url",
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+	}
+	if err := models.CloseOpenReports(tx, url.Id, reportStatus); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error updating reports",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	shorts := []string{}
+	owner := &models.User{Id: url.UserId}
+	ownerWasDisabled := false
+	if banOwner {
+		if err := owner.GetUserById(tx); err != nil {
+			tx.Rollback()
+			return userLookupError(c, err)
+		}
+		ownerWasDisabled = owner.Disabled
+		if err := tx.Model(owner).Update("disabled", true).Error; err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Error updating user",
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+		ownerShorts, err := models.GetUrlKeysByUserId(tx, owner.Id)
+		if err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Error updating user",
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+		shorts = append(shorts, ownerShorts...)
+	}
+	tx.Commit()
+	purgeAliasCache(config.GetMySQLClient(), url)
+	purgeUrlCache(shorts...)
+	action := models.AUDIT_REPORTS_DISMISS
+	if urlStatus == models.URL_STATUS_TAKEN_DOWN {
+		action = models.AUDIT_URL_TAKEDOWN
+	}
+	recordAudit(c, currentUserId(c), action, models.AUDIT_TARGET_URL, url.Id, before, auditUrl(url))
+	if banOwner {
+		recordAudit(c, currentUserId(c), models.AUDIT_USER_STATUS, models.AUDIT_TARGET_USER, owner.Id,
+			fiber.Map{"disabled": ownerWasDisabled}, fiber.Map{"disabled": true})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Reports updated successfully",
+		"success": true,
+		"data":    url,
+	})
+}
+
+func urlLookupError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Url not found",
+			"success": false,
+			"error":   "Url not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"message": "Error getting url",
+		"success": false,
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,250 @@
+package routes
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	RESERVED_WORDS_CACHE_TTL    = time.Minute
+	MAX_RESERVED_PATTERN_LENGTH = 64
+)
+
+// used when RESERVED_WORDS_FILE is not set
+var defaultReservedWords = []string{
+	"admin", "api", "www", "mail", "ftp", "localhost", "about", "contact",
+	"help", "support", "login", "logout", "register", "signup", "signin",
+	"dashboard", "settings", "profile", "account", "delete", "edit", "create",
+	"update", "new", "old", "test", "demo", "example", "shorten", "url",
+	"link", "stats", "analytics", "report", "export", "import", "search",
+	"filter", "sort", "page", "next", "prev", "first", "last", "home",
+}
+
+var (
+	configuredReserved     []string
+	configuredReservedOnce sync.Once
+	// first path segments of the registered routes, set once before serving
+	routeSegments = map[string]bool{}
+)
+
+// adminReserved caches the reserved words admins added through the API
+var adminReserved struct {
+	mu       sync.Mutex
+	patterns []string
+	loadedAt time.Time
+}
+
+type ReservedWordRequest struct {
+	Pattern string `json:"pattern"`
+}
+
+// ReserveRoutes reserves the first segment of every route registered on app,
+// so no short code or handle can take the path of a route
+func ReserveRoutes(app *fiber.App) {
+	for _, route := range app.GetRoutes(true) {
+		segment, _, _ := strings.Cut(strings.TrimPrefix(route.Path, "/"), "/")
+		if segment == "" || strings.ContainsAny(segment, ":*+") {
+			continue
+		}
+		routeSegments[strings.ToLower(segment)] = true
+	}
+}
+
+// isRouteSegment reports whether a registered route starts with the segment,
+// routes match regardless of case
+func isRouteSegment(segment string) bool {
+	return routeSegments[strings.ToLower(segment)]
+}
+
+// loadConfiguredReserved reads RESERVED_WORDS_FILE, one word or glob pattern
+// per line with # comments, and falls back to the built-in list
+func loadConfiguredReserved() {
+	configuredReserved = defaultReservedWords
+	filePath := os.Getenv("RESERVED_WORDS_FILE")
+	if filePath == "" {
+		return
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		utils.Log("error reading RESERVED_WORDS_FILE, using the built-in list: " + err.Error())
+		return
+	}
+	defer file.Close()
+	patterns := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		pattern := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			utils.Log("skipping invalid reserved word pattern " + pattern)
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	if err := scanner.Err(); err != nil {
+		utils.Log("error reading RESERVED_WORDS_FILE: " + err.Error())
+	}
+	configuredReserved = patterns
+}
+
+// adminReservedPatterns returns the admin added patterns, reloaded every
+// RESERVED_WORDS_CACHE_TTL so changes on other instances apply too
+func adminReservedPatterns() []string {
+	adminReserved.mu.Lock()
+	defer adminReserved.mu.Unlock()
+	if time.Since(adminReserved.loadedAt) > RESERVED_WORDS_CACHE_TTL {
+		words, err := models.GetReservedWords(config.GetMySQLClient())
+		if err != nil {
+			// keep the previous list until the database is back
+			utils.Log("error loading reserved words: " + err.Error())
+			return adminReserved.patterns
+		}
+		patterns := make([]string, len(words))
+		for i, word := range words {
+			patterns[i] = word.Pattern
+		}
+		adminReserved.patterns, adminReserved.loadedAt = patterns, time.Now()
+	}
+	return adminReserved.patterns
+}
+
+// reloadAdminReserved makes the next check load the admin patterns again
+func reloadAdminReserved() {
+	adminReserved.mu.Lock()
+	adminReserved.loadedAt = time.Time{}
+	adminReserved.mu.Unlock()
+}
+
+// reservedMatch returns the route segment, word or pattern that reserves
+// value, empty when value is free
+func reservedMatch(value string) string {
+	value = strings.ToLower(value)
+	if isRouteSegment(value) {
+		return value
+	}
+	configuredReservedOnce.Do(loadConfiguredReserved)
+	for _, patterns := range [][]string{configuredReserved, adminReservedPatterns()} {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, value); matched {
+				return pattern
+			}
+		}
+	}
+	return ""
+}
+
+// AdminListReservedWords returns the reserved route segments, the configured
+// words and the words added by admins
+func AdminListReservedWords(c *fiber.Ctx) error {
+	words, err := models.GetReservedWords(config.GetMySQLClient())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting reserved words",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	configuredReservedOnce.Do(loadConfiguredReserved)
+	segments := []string{}
+	for segment := range routeSegments {
+		segments = append(segments, segment)
+	}
+	sort.Strings(segments)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Reserved words fetched successfully",
+		"success": true,
+		"data": fiber.Map{
+			"routes":     segments,
+			"configured": configuredReserved,
+			"custom":     words,
+		},
+	})
+}
+
+// AdminAddReservedWord reserves a word or glob pattern such as "promo-*",
+// existing links that match keep working
+func AdminAddReservedWord(c *fiber.Ctx) error {
+	req := new(ReservedWordRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	pattern := strings.ToLower(strings.TrimSpace(req.Pattern))
+	if pattern == "" || len(pattern) > MAX_RESERVED_PATTERN_LENGTH {
+		return invalidReservedPatternResponse(c)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return invalidReservedPatternResponse(c)
+	}
+	word := &models.ReservedWord{Pattern: pattern, CreatedBy: currentUserId(c)}
+	if err := word.CreateReservedWord(config.GetMySQLClient()); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"message": "Reserved word already exists",
+				"success": false,
+				"error":   "Reserved word already exists",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error adding reserved word",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	reloadAdminReserved()
+	recordAudit(c, currentUserId(c), models.AUDIT_RESERVED_ADD, models.AUDIT_TARGET_RESERVED, word.Id, nil, fiber.Map{"pattern": pattern})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Reserved word added successfully",
+		"success": true,
+		"data":    word,
+	})
+}
+
+func AdminDeleteReservedWord(c *fiber.Ctx) error {
+	word := &models.ReservedWord{Id: c.Params("id")}
+	if err := word.DeleteReservedWord(config.GetMySQLClient()); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"message": "Reserved word not found",
+				"success": false,
+				"error":   "Reserved word not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error deleting reserved word",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	reloadAdminReserved()
+	recordAudit(c, currentUserId(c), models.AUDIT_RESERVED_REMOVE, models.AUDIT_TARGET_RESERVED, word.Id, fiber.Map{"pattern": word.Pattern}, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Reserved word deleted successfully",
+		"success": true,
+	})
+}
+
+func invalidReservedPatternResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"message": "Invalid pattern",
+		"success": false,
+		"error":   "pattern must be a word or glob pattern of at most 64 characters, e.g. promo-*",
+	})
+}
@@ -1,46 +1,67 @@
 package routes
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
+	"html/template"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/ydv-ankit/go-url-shortener/config"
 	"github.com/ydv-ankit/go-url-shortener/models"
-	"gorm.io/gorm"
+	"github.com/ydv-ankit/go-url-shortener/policy"
+	"github.com/ydv-ankit/go-url-shortener/utils"
 )
 
-type CacheUrl struct {
-	Id     string    `json:"id"`
-	Long   string    `json:"long"`
-	Short  string    `json:"short"`
-	Expiry time.Time `json:"expiry"`
+var warningPage = template.Must(template.New("warning").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Warning: unsafe link</title>
+</head>
+<body style="font-family: sans-serif; max-width: 40rem; margin: 4rem auto; padding: 0 1rem;">
+<h1>This link has been blocked</h1>
+<p>The destination of this short link has been flagged as potentially harmful, for example as phishing or malware, so we are not redirecting you.</p>
+<p>Destination: <code>{{.}}</code></p>
+</body>
+</html>`))
+
+// recordPolicyMatch stores the policy rule a url matches for moderators and
+// drops the stale cache entry
+func recordPolicyMatch(url *models.Url, match string) {
+	go func() {
+		if err := url.SetPolicyMatch(config.GetMySQLClient(), match); err != nil {
+			fmt.Println("error recording policy match:", err)
+			return
+		}
+		purgeAliasCache(config.GetMySQLClient(), url)
+	}()
 }
 
 func ResolveUrl(c *fiber.Ctx) error {
-	short := c.Params("short")
-	url := new(models.Url)
-	url.Short = short
+	// routes registered after this one win over a code that shadows them
+	if isRouteSegment(c.Params("short")) {
+		return c.Next()
+	}
+	return resolveUrl(c, &models.Alias{Domain: requestDomain(c), Short: c.Params("short")})
+}
 
-	var tx *gorm.DB
+// ResolveNamespacedUrl resolves /u/{handle}/{slug}
+func ResolveNamespacedUrl(c *fiber.Ctx) error {
+	return resolveUrl(c, &models.Alias{Domain: requestDomain(c), Namespace: strings.ToLower(c.Params("handle")), Short: c.Params("slug")})
+}
 
+// resolveUrl redirects to the url of the alias with the domain, namespace and
+// short code set in alias
+func resolveUrl(c *fiber.Ctx, alias *models.Alias) error {
+	url := new(models.Url)
 	// check for cache hit
-	r, err := config.GetRedisClient(0).Get(config.RedisCtx, short).Result()
-	if err == nil {
-		cachedUrl := new(CacheUrl)
-		err = json.Unmarshal([]byte(r), cachedUrl)
-		if err != nil {
-			fmt.Println("error unmarshalling url", err)
-		}
-		url.Expiry = cachedUrl.Expiry
-		url.Id = cachedUrl.Id
-		url.Long = cachedUrl.Long
-		url.Short = cachedUrl.Short
-	} else {
-		tx = config.GetMySQLClient().Begin()
+	if err := getCachedUrl(alias, url); err != nil {
+		tx := config.GetMySQLClient().Begin()
 		// cache miss, get from db
-		if err := url.GetUrlByShort(tx); err != nil {
+		if err := url.GetUrlByAlias(tx, alias); err != nil {
 			tx.Rollback()
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"message": "Url not found",
@@ -48,21 +69,33 @@ func ResolveUrl(c *fiber.Ctx) error {
 				"error":   "Url not found",
 			})
 		}
+		// links of disabled accounts stop resolving, workspace links outlive deleted creators
+		owner := &models.User{Id: url.UserId}
+		if err := owner.GetUserById(tx); err == nil && owner.Disabled {
+			tx.Rollback()
+			return c.Status(fiber.StatusGone).JSON(fiber.Map{
+				"message": "Url unavailable",
+				"success": false,
+				"error":   "Url unavailable",
+			})
+		}
 		tx.Commit()
 		// set cache
-		cacheUrl := new(CacheUrl)
-		cacheUrl.Long = url.Long
-		cacheUrl.Short = url.Short
-		cacheUrl.Expiry = url.Expiry
-		cacheUrl.Id = url.Id
-		jsonData, err := json.Marshal(cacheUrl)
-		if err != nil {
-			fmt.Println("error marshalling url", err)
-		}
-		err = config.GetRedisClient(0).Set(config.RedisCtx, short, string(jsonData), time.Minute*30).Err() // 30 minutes TTL
-		if err != nil {
-			fmt.Println("error setting cache", err)
-		}
+		setCachedUrl(alias, url)
+	}
+	if url.Status == models.URL_STATUS_SUSPENDED {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"message": "Url unavailable",
+			"success": false,
+			"error":   "Url has been suspended pending review",
+		})
+	}
+	if !url.IsActive() {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"message": "Url unavailable",
+			"success": false,
+			"error":   "Url has been taken down",
+		})
 	}
 	// check if url is expired
 	if time.Now().After(url.Expiry) {
@@ -73,10 +106,24 @@ func ResolveUrl(c *fiber.Ctx) error {
 		})
 	}
 
+	// destinations are checked on every resolve so blocklist updates apply to existing links
+	verdict := policy.Check(url.Long)
+	if verdict.Reason != url.PolicyMatch {
+		recordPolicyMatch(url, verdict.Reason)
+	}
+	if verdict.Blocked {
+		var page bytes.Buffer
+		if err := warningPage.Execute(&page, url.Long); err != nil {
+			fmt.Println("error rendering warning page:", err)
+		}
+		return c.Status(fiber.StatusForbidden).Type("html").Send(page.Bytes())
+	}
+
 	// Track click
-	go func(ip string, urlId string) {
+	go func(ip string, urlId string, aliasId string) {
 		click := new(models.UrlClick)
 		click.UrlId = urlId
+		click.AliasId = aliasId
 		click.IpAddress = ip
 		tx := config.GetMySQLClient().Begin()
 		if err := click.CreateClick(tx); err != nil {
@@ -85,7 +132,7 @@ func ResolveUrl(c *fiber.Ctx) error {
 			return
 		}
 		tx.Commit()
-	}(c.IP(), url.Id)
+	}(utils.PrivacyIP(c.IP()), url.Id, alias.Id)
 
 	return c.SendString(url.Long)
 }
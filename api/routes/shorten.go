@@ -3,14 +3,19 @@ package routes
 import (
 	"errors"
 	"fmt"
-	"math/rand"
+	"math"
+	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/ydv-ankit/go-url-shortener/config"
 	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/policy"
+	"github.com/ydv-ankit/go-url-shortener/shortcode"
+	"github.com/ydv-ankit/go-url-shortener/utils"
 	"gorm.io/gorm"
 )
 
@@ -19,16 +24,47 @@ const (
 	SHORT_URL_LENGTH      = 7
 	MIN_CUSTOM_LENGTH     = 3
 	MAX_CUSTOM_LENGTH     = 20
+	// collisions in a row before a longer code is tried
+	SHORT_URL_GROW_AFTER        = 3
+	SHORT_CODE_MAX_FILL_PERCENT = 1
+	GENERATED_COUNT_TTL         = time.Minute
+	// separators custom short codes may contain
+	SLUG_SEPARATORS = "-_"
 )
 
-// Reserved words that cannot be used as custom short codes
-var reservedWords = []string{
-	"admin", "api", "www", "mail", "ftp", "localhost", "about", "contact",
-	"help", "support", "login", "logout", "register", "signup", "signin",
-	"dashboard", "settings", "profile", "account", "delete", "edit", "create",
-	"update", "new", "old", "test", "demo", "example", "shorten", "url",
-	"link", "stats", "analytics", "report", "export", "import", "search",
-	"filter", "sort", "page", "next", "prev", "first", "last", "home",
+var errShortTaken = errors.New("short code is already taken")
+
+// generatedLinks caches the number of generated codes for shortCodeLength
+var generatedLinks struct {
+	mu        sync.Mutex
+	count     int64
+	countedAt time.Time
+}
+
+// slugSeparators returns the separators allowed in custom short codes, set with
+// SHORTCODE_SEPARATORS: any of "-" and "_", or "none"
+func slugSeparators() string {
+	value := os.Getenv("SHORTCODE_SEPARATORS")
+	if value == "" {
+		return SLUG_SEPARATORS
+	}
+	separators := ""
+	for _, separator := range SLUG_SEPARATORS {
+		if strings.ContainsRune(value, separator) {
+			separators += string(separator)
+		}
+	}
+	return separators
+}
+
+// matchesSlug reports whether value is made of runs of the chars character
+// range joined by single separators
+func matchesSlug(value string, chars string, separators string) (bool, error) {
+	pattern := "^[" + chars + "]+$"
+	if separators != "" {
+		pattern = "^[" + chars + "]+([" + regexp.QuoteMeta(separators) + "][" + chars + "]+)*$"
+	}
+	return regexp.MatchString(pattern, value)
 }
 
 // validateCustomShort validates a custom short code
@@ -45,62 +81,206 @@ func validateCustomShort(customShort string) error {
 		return fmt.Errorf("custom short code must be at most %d characters", MAX_CUSTOM_LENGTH)
 	}
 
-	// Check if alphanumeric only
-	matched, err := regexp.MatchString("^[a-zA-Z0-9]+$", customShort)
+	// Check characters, separators only between alphanumeric runs
+	separators := slugSeparators()
+	matched, err := matchesSlug(customShort, "a-zA-Z0-9", separators)
 	if err != nil {
 		return errors.New("error validating custom short code")
 	}
 	if !matched {
-		return errors.New("custom short code must contain only alphanumeric characters (a-z, A-Z, 0-9)")
+		if separators == "" {
+			return errors.New("custom short code must contain only alphanumeric characters (a-z, A-Z, 0-9)")
+		}
+		return fmt.Errorf("custom short code must contain only alphanumeric characters (a-z, A-Z, 0-9) and %s, not at the start or end and not doubled", strings.Join(strings.Split(separators, ""), " "))
 	}
 
-	// Check if reserved word
-	customShortLower := strings.ToLower(customShort)
-	for _, reserved := range reservedWords {
-		if customShortLower == reserved {
-			return fmt.Errorf("'%s' is a reserved word and cannot be used", customShort)
-		}
+	// Check reserved words and route segments
+	if reservedMatch(customShort) != "" {
+		return fmt.Errorf("'%s' is a reserved word and cannot be used", customShort)
+	}
+
+	if utils.ContainsBannedWord(customShort) {
+		return errors.New("custom short code contains a word that is not allowed")
 	}
 
 	return nil
 }
 
-// checkShortAvailability checks if a short code is available
-func checkShortAvailability(tx *gorm.DB, short string) (bool, error) {
-	url := &models.Url{Short: short}
-	err := url.GetUrlByShort(tx)
+// shortCodeLength returns the length of generated codes: SHORTCODE_LENGTH,
+// grown until generated links fill at most SHORTCODE_MAX_FILL_PERCENT of the
+// keyspace, which keeps the chance of a random collision below that percentage
+func shortCodeLength(tx *gorm.DB) (int, error) {
+	generatedLinks.mu.Lock()
+	defer generatedLinks.mu.Unlock()
+	if time.Since(generatedLinks.countedAt) > GENERATED_COUNT_TTL {
+		count, err := models.CountGeneratedUrls(tx)
+		if err != nil {
+			return 0, err
+		}
+		generatedLinks.count, generatedLinks.countedAt = count, time.Now()
+	}
+	length := utils.GetEnvInt("SHORTCODE_LENGTH", SHORT_URL_LENGTH)
+	fillPercent := float64(utils.GetEnvInt("SHORTCODE_MAX_FILL_PERCENT", SHORT_CODE_MAX_FILL_PERCENT))
+	for float64(generatedLinks.count) >= math.Pow(62, float64(length))*fillPercent/100 {
+		length++
+	}
+	return length, nil
+}
+
+// createUrlOnce inserts the url with its primary alias, a duplicate short code
+// returns errShortTaken and leaves the transaction usable
+func createUrlOnce(tx *gorm.DB, url *models.Url) error {
+	tx.SavePoint("create_url")
+	err := url.CreateUrl(tx)
+	if err == nil {
+		alias := &models.Alias{UrlId: url.Id, Domain: url.Domain, Namespace: url.Namespace, Short: url.Short, Primary: true, Custom: url.Custom}
+		err = alias.CreateAlias(tx)
+	}
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return true, nil // Available
+		tx.RollbackTo("create_url")
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errShortTaken
 		}
-		return false, err // Error checking
+		return err
 	}
-	return false, nil // Not available
+	return nil
 }
 
-func generateShortUrl(tx *gorm.DB, retry int) (string, error) {
-	if retry > SHORT_URL_RETRY_LIMIT {
-		return "", errors.New("failed to generate short url")
+// createAliasOnce inserts the alias, a duplicate short code returns
+// errShortTaken and leaves the transaction usable
+func createAliasOnce(tx *gorm.DB, alias *models.Alias) error {
+	tx.SavePoint("create_alias")
+	if err := alias.CreateAlias(tx); err != nil {
+		tx.RollbackTo("create_alias")
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errShortTaken
+		}
+		return err
 	}
-	// generate random string of 7 characters using base62
-	random := rand.New(rand.NewSource(time.Now().UnixNano()))
-	chars := "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	shortUrl := make([]byte, SHORT_URL_LENGTH)
-	for i := range shortUrl {
-		shortUrl[i] = chars[random.Intn(len(chars))]
+	return nil
+}
+
+// insertUrl inserts a url with its custom code, or with generated codes until
+// one is free
+func insertUrl(tx *gorm.DB, url *models.Url) error {
+	if url.Custom {
+		return createUrlOnce(tx, url)
 	}
-	// check if short url already exists using the transaction to ensure atomicity
-	if err := (&models.Url{Short: string(shortUrl)}).GetUrlByShort(tx); err == nil {
-		fmt.Println("short url already exists", string(shortUrl))
-		return generateShortUrl(tx, retry+1)
+	return insertGenerated(tx, generatedCode, func(short string) error {
+		url.Short = short
+		return createUrlOnce(tx, url)
+	})
+}
+
+// insertDerived inserts a url with codes derived from its fingerprint. When a
+// derived code belongs to a live url with the same fingerprint, e.g. one created
+// by a concurrent request, that url is loaded into url and existing is true.
+func insertDerived(tx *gorm.DB, url *models.Url, key string) (existing bool, err error) {
+	err = insertGenerated(tx, func(length int, attempt int) (string, error) {
+		return shortcode.Derive(key, url.Fingerprint, attempt, length), nil
+	}, func(short string) error {
+		url.Short = short
+		err := createUrlOnce(tx, url)
+		if !errors.Is(err, errShortTaken) {
+			return err
+		}
+		// read outside the transaction, its snapshot can predate the other insert
+		taken := &models.Url{Domain: url.Domain, Short: short}
+		if taken.GetUrlByShort(config.GetMySQLClient()) == nil && taken.Fingerprint == url.Fingerprint &&
+			taken.IsActive() && time.Now().Before(taken.Expiry) {
+			*url, existing = *taken, true
+			return nil
+		}
+		return err
+	})
+	return existing, err
+}
+
+// insertAlias inserts an alias with its custom code, or with generated codes
+// until one is free
+func insertAlias(tx *gorm.DB, alias *models.Alias) error {
+	if alias.Custom {
+		return createAliasOnce(tx, alias)
+	}
+	return insertGenerated(tx, generatedCode, func(short string) error {
+		alias.Short = short
+		return createAliasOnce(tx, alias)
+	})
+}
+
+// generatedCode returns a pooled code, or generates one when the pool runs dry
+func generatedCode(length int, attempt int) (string, error) {
+	if shortUrl, ok := popPooledCode(length); ok {
+		return shortUrl, nil
+	}
+	return shortcode.Default().Generate(length)
+}
+
+// insertGenerated calls create with the codes returned by next until it does
+// not return errShortTaken
+func insertGenerated(tx *gorm.DB, next func(length int, attempt int) (string, error), create func(short string) error) error {
+	length, err := shortCodeLength(tx)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; attempt <= SHORT_URL_RETRY_LIMIT; attempt++ {
+		// repeated collisions mean the keyspace is crowded, grow the code
+		if attempt%SHORT_URL_GROW_AFTER == 0 {
+			length++
+		}
+		shortUrl, err := next(length, attempt)
+		if err != nil {
+			return err
+		}
+		// codes can spell offensive words, try again
+		if utils.ContainsBannedWord(shortUrl) {
+			continue
+		}
+		err = create(shortUrl)
+		if errors.Is(err, errShortTaken) {
+			utils.Log("short url already exists " + shortUrl)
+			continue
+		}
+		return err
+	}
+	return errors.New("failed to generate short url")
+}
+
+// invalidDestinationResponse reports a rejected long url with its error code
+func invalidDestinationResponse(c *fiber.Ctx, err error) error {
+	code := utils.DEST_MALFORMED
+	var destErr *utils.DestinationError
+	if errors.As(err, &destErr) {
+		code = destErr.Code
 	}
-	return string(shortUrl), nil
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"message": "Invalid destination url",
+		"success": false,
+		"error":   err.Error(),
+		"code":    code,
+	})
 }
 
 type ShortenUrlRequest struct {
 	Long        string    `json:"long"`
 	CustomShort string    `json:"customShort,omitempty"`
 	Expiry      time.Time `json:"expiry,omitempty"`
+	// create the custom short code under the user's handle
+	Namespaced bool `json:"namespaced,omitempty"`
+	// derive the code from the owner and long url, shortening the same long url
+	// again returns the existing link
+	Deterministic bool `json:"deterministic,omitempty"`
+	// verified custom domain of the user the link is served on
+	Domain string `json:"domain,omitempty"`
+}
+
+// existingUrlResponse returns the link a deterministic request already created
+func existingUrlResponse(c *fiber.Ctx, url *models.Url) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Short url already exists",
+		"success": true,
+		"data":    url,
+	})
 }
 
 func ShortenUrl(c *fiber.Ctx) error {
@@ -114,6 +294,56 @@ func ShortenUrl(c *fiber.Ctx) error {
 		})
 	}
 
+	// Validate and canonicalize the destination
+	// verified custom domains are served here too, links to them would loop
+	long, err := utils.NormalizeDestination(req.Long, isCustomDomain)
+	if err != nil {
+		return invalidDestinationResponse(c, err)
+	}
+	req.Long = long
+	if verdict := policy.Check(req.Long); verdict.Blocked {
+		utils.LogSecurityEvent("destination_blocked", map[string]string{
+			"userId": c.Locals("userId").(string),
+			"ip":     c.IP(),
+			"long":   req.Long,
+			"reason": verdict.Reason,
+		})
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Destination not allowed",
+			"success": false,
+			"error":   "This destination has been flagged as harmful and cannot be shortened",
+			"code":    "destination_blocked",
+		})
+	}
+
+	if req.Namespaced && req.CustomShort == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid custom short code",
+			"success": false,
+			"error":   "customShort is required for namespaced links",
+		})
+	}
+
+	// deterministic codes are keyed with SHORTCODE_HASH_KEY, so they cannot be
+	// predicted without it
+	hashKey := os.Getenv("SHORTCODE_HASH_KEY")
+	if req.Deterministic {
+		if hashKey == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Deterministic short codes are not enabled",
+				"success": false,
+				"error":   "Deterministic short codes are not enabled",
+			})
+		}
+		if req.CustomShort != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid custom short code",
+				"success": false,
+				"error":   "Deterministic links cannot have a custom short code",
+			})
+		}
+	}
+
 	// Validate custom short code if provided
 	if req.CustomShort != "" {
 		if err := validateCustomShort(req.CustomShort); err != nil {
@@ -126,51 +356,129 @@ func ShortenUrl(c *fiber.Ctx) error {
 	}
 
 	userId := c.Locals("userId").(string)
-	tx := config.GetMySQLClient().Begin()
+	tx := beginQuotaTx()
 
-	var shortUrl string
-	if req.CustomShort != "" {
-		// Check availability of custom short code
-		available, err := checkShortAvailability(tx, req.CustomShort)
-		if err != nil {
+	// editors and owners can create links in the selected workspace
+	workspaceId, err := workspaceScope(c, tx, models.WORKSPACE_ROLE_EDITOR)
+	if err != nil {
+		tx.Rollback()
+		return workspaceError(c, err)
+	}
+
+	domain, err := linkDomain(tx, userId, req.Domain)
+	if err != nil {
+		tx.Rollback()
+		return linkDomainError(c, err)
+	}
+
+	// namespaced links live under the creator's handle
+	namespace := ""
+	if req.Namespaced {
+		if workspaceId != "" {
 			tx.Rollback()
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"message": "Error checking short code availability",
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid namespace",
 				"success": false,
-				"error":   err.Error(),
+				"error":   "Workspace links cannot be namespaced",
 			})
 		}
-		if !available {
+		user := &models.User{Id: userId}
+		if err := user.GetUserById(tx); err != nil {
 			tx.Rollback()
-			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-				"message": "Custom short code is already taken",
+			return userLookupError(c, err)
+		}
+		if namespace = user.HandleName(); namespace == "" {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid namespace",
 				"success": false,
-				"error":   "The custom short code you requested is already in use",
+				"error":   errNoHandle.Error(),
 			})
 		}
-		shortUrl = req.CustomShort
-	} else {
-		// Generate random short URL
-		shortUrl, err = generateShortUrl(tx, 0)
-		if err != nil {
+	}
+
+	// the same owner shortening the same long url gets the live link back
+	fingerprint := ""
+	if req.Deterministic {
+		input := usageScopeId(userId, workspaceId) + "\n" + req.Long
+		if domain != "" {
+			input += "\n" + domain
+		}
+		fingerprint = shortcode.Fingerprint(hashKey, input)
+		existing := &models.Url{Fingerprint: fingerprint}
+		err := existing.GetUrlByFingerprint(tx, userId, workspaceId)
+		if err == nil {
+			tx.Rollback()
+			return existingUrlResponse(c, existing)
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
 			tx.Rollback()
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"message": "Failed to generate short url",
+				"message": "Error creating short url",
 				"success": false,
 				"error":   err.Error(),
 			})
 		}
 	}
 
+	// plan limits of the personal or workspace scope
+	if err := checkPlanLimits(tx, userId, workspaceId, req.CustomShort != "", &req.Expiry); err != nil {
+		tx.Rollback()
+		return quotaErrorResponse(c, err)
+	}
+
 	url := &models.Url{
-		UserId: userId,
-		Long:   req.Long,
-		Short:  shortUrl,
-		Expiry: req.Expiry,
+		UserId:      userId,
+		WorkspaceId: workspaceId,
+		Domain:      domain,
+		Namespace:   namespace,
+		Long:        req.Long,
+		Short:       req.CustomShort,
+		Expiry:      req.Expiry,
+		Custom:      req.CustomShort != "",
+		Fingerprint: fingerprint,
 	}
 
-	// create new url
-	if err := url.CreateUrl(tx); err != nil {
+	// create new url, the unique index on namespace and short decides who gets a code
+	existing := false
+	if req.Deterministic {
+		existing, err = insertDerived(tx, url, hashKey)
+	} else {
+		err = insertUrl(tx, url)
+	}
+	if existing {
+		tx.Rollback()
+		return existingUrlResponse(c, url)
+	}
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, errShortTaken) {
+			message := "The custom short code you requested is already in use"
+			if namespace != "" {
+				message += " in your namespace"
+			}
+			// free alternatives the client can offer instead
+			user := &models.User{Id: userId}
+			user.GetUserById(config.GetMySQLClient())
+			suggestions, err := suggestAliases(config.GetMySQLClient(), req.CustomShort, domain, namespace, suggestionHandle(user, namespace))
+			if err != nil {
+				utils.Log("error suggesting aliases: " + err.Error())
+				suggestions = []string{}
+			}
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"message":     "Custom short code is already taken",
+				"success":     false,
+				"error":       message,
+				"suggestions": suggestions,
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error creating short url",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if err := models.IncrementLinksCreated(tx, usageScopeId(userId, workspaceId)); err != nil {
 		tx.Rollback()
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Error creating short url",
@@ -179,6 +487,7 @@ func ShortenUrl(c *fiber.Ctx) error {
 		})
 	}
 	tx.Commit()
+	recordAudit(c, userId, models.AUDIT_URL_SHORTEN, models.AUDIT_TARGET_URL, url.Id, nil, auditUrl(url))
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Short url created successfully",
 		"success": true,
@@ -0,0 +1,350 @@
+package routes
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	TWO_FACTOR_CHALLENGE_TTL  = time.Minute * 5
+	TWO_FACTOR_MAX_ATTEMPTS   = 5
+	TWO_FACTOR_ATTEMPT_WINDOW = time.Minute * 15
+)
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorLoginRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+type TwoFactorDisableRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+func twoFactorChallengeKey(challenge string) string {
+	return "2fa:challenge:" + challenge
+}
+
+func twoFactorAttemptsKey(userId string) string {
+	return "2fa:attempts:" + userId
+}
+
+// createTwoFactorChallenge stores a short lived partial-auth challenge for the user
+func createTwoFactorChallenge(userId string) (string, error) {
+	challenge, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = config.GetRedisClient(0).Set(config.RedisCtx, twoFactorChallengeKey(challenge), userId, TWO_FACTOR_CHALLENGE_TTL).Err()
+	if err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// twoFactorThrottled reports whether the user has used up their failed attempts
+func twoFactorThrottled(userId string) bool {
+	count, err := config.GetRedisClient(0).Get(config.RedisCtx, twoFactorAttemptsKey(userId)).Int()
+	return err == nil && count >= TWO_FACTOR_MAX_ATTEMPTS
+}
+
+func recordTwoFactorFailure(userId string) {
+	rdb := config.GetRedisClient(0)
+	count, err := rdb.Incr(config.RedisCtx, twoFactorAttemptsKey(userId)).Result()
+	if err != nil {
+		utils.Log("error recording two-factor failure: " + err.Error())
+		return
+	}
+	if count == 1 {
+		rdb.Expire(config.RedisCtx, twoFactorAttemptsKey(userId), TWO_FACTOR_ATTEMPT_WINDOW)
+	}
+}
+
+func resetTwoFactorFailures(userId string) {
+	config.GetRedisClient(0).Del(config.RedisCtx, twoFactorAttemptsKey(userId))
+}
+
+// verifySecondFactor accepts either a current totp code or an unused recovery code
+func verifySecondFactor(tx *gorm.DB, user *models.User, code string) bool {
+	if utils.ValidateTotp(code, user.TotpSecret) {
+		// reject replay of a code that was already accepted in its validity window
+		ok, err := config.GetRedisClient(0).SetNX(config.RedisCtx, "2fa:used:"+user.Id+":"+code, 1, time.Second*utils.TOTP_PERIOD*3).Result()
+		return err == nil && ok
+	}
+	return models.UseRecoveryCode(tx, user.Id, utils.HashRecoveryCode(code)) == nil
+}
+
+func twoFactorThrottledResponse(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderRetryAfter, "900")
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"message": "Too many failed attempts",
+		"success": false,
+		"error":   "Too many failed two-factor attempts, try again later",
+	})
+}
+
+func EnrollTwoFactor(c *fiber.Ctx) error {
+	user := &models.User{Id: c.Locals("userId").(string)}
+	tx := config.GetMySQLClient().Begin()
+	if err := user.GetUserById(tx); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "User not found",
+			"success": false,
+			"error":   "User not found",
+		})
+	}
+	if user.TotpEnabled {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Two-factor authentication is already enabled",
+			"success": false,
+			"error":   "Two-factor authentication is already enabled",
+		})
+	}
+	key, err := utils.GenerateTotpKey(user.Email)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error generating two-factor secret",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	qr, err := utils.TotpQRCode(key)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error generating qr code",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	// secret stays pending until a code is verified
+	if err := tx.Model(user).Update("totp_secret", key.Secret()).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error saving two-factor secret",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Scan the qr code and verify a code to enable two-factor authentication",
+		"success": true,
+		"data": fiber.Map{
+			"secret": key.Secret(),
+			"uri":    key.URL(),
+			"qrCode": qr,
+		},
+	})
+}
+
+func VerifyTwoFactor(c *fiber.Ctx) error {
+	req := new(TwoFactorCodeRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	user := &models.User{Id: c.Locals("userId").(string)}
+	if twoFactorThrottled(user.Id) {
+		return twoFactorThrottledResponse(c)
+	}
+	tx := config.GetMySQLClient().Begin()
+	if err := user.GetUserById(tx); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "User not found",
+			"success": false,
+			"error":   "User not found",
+		})
+	}
+	if user.TotpEnabled || user.TotpSecret == "" {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "No pending two-factor enrollment",
+			"success": false,
+			"error":   "Start enrollment before verifying a code",
+		})
+	}
+	if !utils.ValidateTotp(req.Code, user.TotpSecret) {
+		tx.Rollback()
+		recordTwoFactorFailure(user.Id)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Invalid two-factor code",
+			"success": false,
+			"error":   "Invalid two-factor code",
+		})
+	}
+	codes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error generating recovery codes",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashRecoveryCode(code)
+	}
+	if err := models.ReplaceRecoveryCodes(tx, user.Id, hashes); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error saving recovery codes",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if err := tx.Model(user).Update("totp_enabled", true).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error enabling two-factor authentication",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	resetTwoFactorFailures(user.Id)
+	recordAudit(c, user.Id, models.AUDIT_USER_2FA_ENABLE, models.AUDIT_TARGET_USER, user.Id,
+		fiber.Map{"totpEnabled": false}, fiber.Map{"totpEnabled": true})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Two-factor authentication enabled, store the recovery codes somewhere safe",
+		"success": true,
+		"data": fiber.Map{
+			"recoveryCodes": codes,
+		},
+	})
+}
+
+func DisableTwoFactor(c *fiber.Ctx) error {
+	req := new(TwoFactorDisableRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	user := &models.User{Id: c.Locals("userId").(string)}
+	if twoFactorThrottled(user.Id) {
+		return twoFactorThrottledResponse(c)
+	}
+	tx := config.GetMySQLClient().Begin()
+	if err := user.GetUserById(tx); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "User not found",
+			"success": false,
+			"error":   "User not found",
+		})
+	}
+	if !user.TotpEnabled {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Two-factor authentication is not enabled",
+			"success": false,
+			"error":   "Two-factor authentication is not enabled",
+		})
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil || !verifySecondFactor(tx, user, req.Code) {
+		tx.Rollback()
+		recordTwoFactorFailure(user.Id)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Invalid credentials",
+			"success": false,
+			"error":   "Invalid password or two-factor code",
+		})
+	}
+	if err := models.DeleteRecoveryCodesByUserId(tx, user.Id); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error deleting recovery codes",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if err := tx.Model(user).Updates(map[string]interface{}{"totp_enabled": false, "totp_secret": ""}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error disabling two-factor authentication",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	resetTwoFactorFailures(user.Id)
+	recordAudit(c, user.Id, models.AUDIT_USER_2FA_DISABLE, models.AUDIT_TARGET_USER, user.Id,
+		fiber.Map{"totpEnabled": true}, fiber.Map{"totpEnabled": false})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Two-factor authentication disabled",
+		"success": true,
+	})
+}
+
+// LoginTwoFactor completes a login started by LoginUser for accounts with 2fa enabled
+func LoginTwoFactor(c *fiber.Ctx) error {
+	req := new(TwoFactorLoginRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	// each challenge is redeemed once, concurrent requests cannot both get a
+	// session and a wrong code means logging in again
+	userId, err := config.GetRedisClient(0).GetDel(config.RedisCtx, twoFactorChallengeKey(req.Challenge)).Result()
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Invalid or expired challenge",
+			"success": false,
+			"error":   "Invalid or expired challenge, please log in again",
+		})
+	}
+	if twoFactorThrottled(userId) {
+		return twoFactorThrottledResponse(c)
+	}
+	user := &models.User{Id: userId}
+	tx := config.GetMySQLClient().Begin()
+	if err := user.GetUserById(tx); err != nil {
+		tx.Rollback()
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = fiber.StatusUnauthorized
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"message": "Invalid or expired challenge",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if !verifySecondFactor(tx, user, req.Code) {
+		tx.Rollback()
+		recordTwoFactorFailure(user.Id)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Invalid two-factor code",
+			"success": false,
+			"error":   "Invalid two-factor code",
+		})
+	}
+	tx.Commit()
+	resetTwoFactorFailures(user.Id)
+	return issueSession(c, user)
+}
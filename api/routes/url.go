@@ -14,8 +14,21 @@ type UrlWithClicks struct {
 func GetAllUrlsByUserId(c *fiber.Ctx) error {
 	userId := c.Locals("userId").(string)
 	tx := config.GetMySQLClient().Begin()
+	workspaceId, err := workspaceScope(c, tx, models.WORKSPACE_ROLE_VIEWER)
+	if err != nil {
+		tx.Rollback()
+		return workspaceError(c, err)
+	}
+	query := tx.Where("user_id = ? AND workspace_id = ''", userId)
+	if workspaceId != "" {
+		query = tx.Where("workspace_id = ?", workspaceId)
+	}
+	// owners can list e.g. their suspended links with ?status=suspended
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
 	urls := []models.Url{}
-	if err := tx.Where("user_id = ?", userId).Order("created_at DESC").Find(&urls).Error; err != nil {
+	if err := query.Order("created_at DESC").Find(&urls).Error; err != nil {
 		tx.Rollback()
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Error getting urls",
@@ -58,7 +71,18 @@ func DeleteUrl(c *fiber.Ctx) error {
 	}
 	url.UserId = userId
 	tx := config.GetMySQLClient().Begin()
-	if err := url.DeleteUrl(tx); err != nil {
+	workspaceId, err := workspaceScope(c, tx, models.WORKSPACE_ROLE_EDITOR)
+	if err != nil {
+		tx.Rollback()
+		return workspaceError(c, err)
+	}
+	url.WorkspaceId = workspaceId
+	// the aliases are deleted with the url, keep their keys to purge
+	keys, err := models.GetAliasKeysByUrlIds(tx, []string{url.Id})
+	if err == nil {
+		err = url.DeleteUrl(tx)
+	}
+	if err != nil {
 		tx.Rollback()
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Error deleting url",
@@ -67,6 +91,8 @@ func DeleteUrl(c *fiber.Ctx) error {
 		})
 	}
 	tx.Commit()
+	purgeUrlCache(keys...)
+	recordAudit(c, userId, models.AUDIT_URL_DELETE, models.AUDIT_TARGET_URL, url.Id, auditUrl(url), nil)
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Url deleted successfully",
 		"success": true,
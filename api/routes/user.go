@@ -2,10 +2,12 @@ package routes
 
 import (
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/middleware"
 	"github.com/ydv-ankit/go-url-shortener/models"
 	"github.com/ydv-ankit/go-url-shortener/utils"
 	"golang.org/x/crypto/bcrypt"
@@ -31,6 +33,14 @@ func CreateUser(c *fiber.Ctx) error {
 			"error":   "User already exists",
 		})
 	}
+	// privileged fields are never taken from the request body, ADMIN_EMAILS
+	// only promotes existing accounts on startup
+	user.Role = models.ROLE_USER
+	user.Disabled = false
+	user.TotpEnabled = false
+	user.Plan = ""
+	// handles are claimed through UpdateProfile, which validates them
+	user.Handle = nil
 	// create new user
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
 	user.Password = string(hashedPassword)
@@ -60,6 +70,11 @@ func CreateUser(c *fiber.Ctx) error {
 			"error":   err.Error(),
 		})
 	}
+	recordAudit(c, user.Id, models.AUDIT_USER_CREATE, models.AUDIT_TARGET_USER, user.Id, nil, fiber.Map{
+		"name":  user.Name,
+		"email": user.Email,
+		"role":  user.Role,
+	})
 	// return success response
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "User created successfully",
@@ -81,43 +96,74 @@ func LoginUser(c *fiber.Ctx) error {
 	}
 	// save password
 	password := user.Password
-	tx := config.GetMySQLClient().Begin()
-	if err := user.GetUserByEmail(tx); err != nil {
-		tx.Rollback()
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"message": "User not found",
+	email := user.Email
+	if lockedFor := loginLockedFor(email, c.IP()); lockedFor > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(lockedFor.Seconds())+1))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"message": "Too many failed login attempts",
 			"success": false,
-			"error":   "User not found",
+			"error":   "Too many failed login attempts, try again later",
 		})
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+	tx := config.GetMySQLClient().Begin()
+	// unknown emails and wrong passwords get the same response
+	var authErr error
+	if err := user.GetUserByEmail(tx); err != nil {
+		compareDummyPassword(password)
+		authErr = err
+	} else {
+		authErr = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	}
+	if authErr != nil {
 		tx.Rollback()
+		recordLoginFailure(email, c.IP())
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"message": "Invalid credentials",
 			"success": false,
 			"error":   "Invalid credentials",
 		})
 	}
-	// generate token
-	token, err := utils.GenerateToken(user.Id)
-	if err != nil {
-		tx.Rollback()
+	tx.Commit()
+	resetLoginFailures(email)
+	if user.Disabled {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Account disabled",
+			"success": false,
+			"error":   "Account disabled",
+		})
+	}
+	// second factor required before a session is issued
+	if user.TotpEnabled {
+		challenge, err := createTwoFactorChallenge(user.Id)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Error creating two-factor challenge",
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message": "Two-factor authentication required",
+			"success": true,
+			"data": fiber.Map{
+				"twoFactorRequired": true,
+				"challenge":         challenge,
+			},
+		})
+	}
+	return issueSession(c, user)
+}
+
+// issueSession sets the auth cookie for a fully authenticated user
+func issueSession(c *fiber.Ctx, user *models.User) error {
+	if err := setSessionCookie(c, user); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Error generating token",
 			"success": false,
 			"error":   err.Error(),
 		})
 	}
-	tx.Commit()
-	tokenCookie := &fiber.Cookie{
-		Name:     "token",
-		Value:    token,
-		Expires:  time.Now().Add(time.Hour * 24),
-		HTTPOnly: true,
-		Secure:   os.Getenv("APP_ENV") == "production",
-		SameSite: "Strict",
-	}
-	c.Cookie(tokenCookie)
+	recordAudit(c, user.Id, models.AUDIT_USER_LOGIN, models.AUDIT_TARGET_USER, user.Id, nil, nil)
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "User logged in successfully",
 		"success": true,
@@ -129,7 +175,26 @@ func LoginUser(c *fiber.Ctx) error {
 	})
 }
 
-func LogoutUser(c *fiber.Ctx) error {
+func setSessionCookie(c *fiber.Ctx, user *models.User) error {
+	token, err := utils.GenerateToken(user.Id, user.TokenVersion)
+	if err != nil {
+		return err
+	}
+	tokenCookie := &fiber.Cookie{
+		Name:     "token",
+		Value:    token,
+		Expires:  time.Now().Add(time.Hour * 24),
+		HTTPOnly: true,
+		Secure:   os.Getenv("APP_ENV") == "production",
+		SameSite: "Strict",
+	}
+	c.Cookie(tokenCookie)
+	// a new session gets a new csrf token
+	middleware.ClearCSRFToken(c)
+	return nil
+}
+
+func clearSessionCookie(c *fiber.Ctx) {
 	// Clear cookie by setting it with same attributes but expired
 	tokenCookie := &fiber.Cookie{
 		Name:     "token",
@@ -140,5 +205,14 @@ func LogoutUser(c *fiber.Ctx) error {
 		SameSite: "Strict",
 	}
 	c.Cookie(tokenCookie)
+	middleware.ClearCSRFToken(c)
+}
+
+func LogoutUser(c *fiber.Ctx) error {
+	// logout is public, the actor comes from the session being ended
+	if userId, _, err := utils.VerifyToken(c.Cookies("token")); err == nil {
+		recordAudit(c, userId, models.AUDIT_USER_LOGOUT, models.AUDIT_TARGET_USER, userId, nil, nil)
+	}
+	clearSessionCookie(c)
 	return c.SendStatus(fiber.StatusOK)
 }
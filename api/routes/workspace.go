@@ -0,0 +1,418 @@
+package routes
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ydv-ankit/go-url-shortener/config"
+	"github.com/ydv-ankit/go-url-shortener/models"
+	"github.com/ydv-ankit/go-url-shortener/utils"
+	"gorm.io/gorm"
+)
+
+const WORKSPACE_HEADER = "X-Workspace-Id"
+
+var (
+	errWorkspaceForbidden = errors.New("you do not have permission for this workspace")
+	errWorkspaceNotMember = errors.New("you are not a member of this workspace")
+)
+
+type WorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+type InvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type AcceptInvitationRequest struct {
+	Token string `json:"token"`
+}
+
+type MemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type WorkspaceWithRole struct {
+	models.Workspace
+	Role string `json:"role"`
+}
+
+type MemberWithUser struct {
+	UserId string `json:"userId"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+}
+
+// selectedWorkspaceId returns the workspace chosen through the X-Workspace-Id
+// header or the workspaceId query param, empty for the personal scope
+func selectedWorkspaceId(c *fiber.Ctx) string {
+	if id := c.Get(WORKSPACE_HEADER); id != "" {
+		return id
+	}
+	return c.Query("workspaceId")
+}
+
+// workspaceScope checks that the caller holds at least minRole in the selected
+// workspace and returns its id. An empty id means the personal scope.
+func workspaceScope(c *fiber.Ctx, tx *gorm.DB, minRole string) (string, error) {
+	workspaceId := selectedWorkspaceId(c)
+	if workspaceId == "" {
+		return "", nil
+	}
+	return workspaceId, requireWorkspaceRole(tx, workspaceId, c.Locals("userId").(string), minRole)
+}
+
+func requireWorkspaceRole(tx *gorm.DB, workspaceId string, userId string, minRole string) error {
+	member := &models.WorkspaceMember{WorkspaceId: workspaceId, UserId: userId}
+	if err := member.GetMember(tx); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errWorkspaceNotMember
+		}
+		return err
+	}
+	if !models.WorkspaceRoleAtLeast(member.Role, minRole) {
+		return errWorkspaceForbidden
+	}
+	return nil
+}
+
+// workspaceError maps workspaceScope errors to a response
+func workspaceError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errWorkspaceNotMember) || errors.Is(err, errWorkspaceForbidden) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"message": "Error checking workspace permissions",
+		"success": false,
+		"error":   err.Error(),
+	})
+}
+
+func CreateWorkspace(c *fiber.Ctx) error {
+	req := new(WorkspaceRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	workspace := &models.Workspace{Name: strings.TrimSpace(req.Name)}
+	tx := config.GetMySQLClient().Begin()
+	if err := workspace.CreateWorkspace(tx, c.Locals("userId").(string)); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Error creating workspace",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Workspace created successfully",
+		"success": true,
+		"data":    WorkspaceWithRole{Workspace: *workspace, Role: models.WORKSPACE_ROLE_OWNER},
+	})
+}
+
+func GetWorkspaces(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(string)
+	workspaces := []WorkspaceWithRole{}
+	err := config.GetMySQLClient().Model(&models.Workspace{}).
+		Select("workspaces.*, workspace_members.role AS role").
+		Joins("JOIN workspace_members ON workspace_members.workspace_id = workspaces.id AND workspace_members.deleted_at IS NULL").
+		Where("workspace_members.user_id = ?", userId).
+		Order("workspaces.created_at ASC").
+		Scan(&workspaces).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting workspaces",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Workspaces fetched successfully",
+		"success": true,
+		"data":    workspaces,
+	})
+}
+
+func GetWorkspaceMembers(c *fiber.Ctx) error {
+	workspaceId := c.Params("id")
+	tx := config.GetMySQLClient()
+	if err := requireWorkspaceRole(tx, workspaceId, c.Locals("userId").(string), models.WORKSPACE_ROLE_VIEWER); err != nil {
+		return workspaceError(c, err)
+	}
+	members := []MemberWithUser{}
+	err := tx.Model(&models.WorkspaceMember{}).
+		Select("workspace_members.user_id, workspace_members.role, users.name, users.email").
+		Joins("JOIN users ON users.id = workspace_members.user_id").
+		Where("workspace_members.workspace_id = ?", workspaceId).
+		Order("workspace_members.created_at ASC").
+		Scan(&members).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error getting members",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Members fetched successfully",
+		"success": true,
+		"data":    members,
+	})
+}
+
+func InviteWorkspaceMember(c *fiber.Ctx) error {
+	req := new(InvitationRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	workspaceId := c.Params("id")
+	userId := c.Locals("userId").(string)
+	tx := config.GetMySQLClient().Begin()
+	if err := requireWorkspaceRole(tx, workspaceId, userId, models.WORKSPACE_ROLE_OWNER); err != nil {
+		tx.Rollback()
+		return workspaceError(c, err)
+	}
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error creating invitation",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	invitation := &models.WorkspaceInvitation{
+		WorkspaceId: workspaceId,
+		Email:       strings.ToLower(strings.TrimSpace(req.Email)),
+		Role:        req.Role,
+		Token:       token,
+		InvitedBy:   userId,
+	}
+	if err := invitation.CreateInvitation(tx); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Error creating invitation",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	// the token is handed to the inviter to share, it is not retrievable later
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Invitation created successfully",
+		"success": true,
+		"data": fiber.Map{
+			"invitation": invitation,
+			"token":      token,
+		},
+	})
+}
+
+func AcceptWorkspaceInvitation(c *fiber.Ctx) error {
+	req := new(AcceptInvitationRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	user := &models.User{Id: c.Locals("userId").(string)}
+	tx := config.GetMySQLClient().Begin()
+	if err := user.GetUserById(tx); err != nil {
+		tx.Rollback()
+		return userLookupError(c, err)
+	}
+	invitation := &models.WorkspaceInvitation{Token: req.Token}
+	if err := invitation.GetInvitationByToken(tx); err != nil || time.Now().After(invitation.ExpiresAt) {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Invitation not found",
+			"success": false,
+			"error":   "Invitation not found or expired",
+		})
+	}
+	if !strings.EqualFold(invitation.Email, user.Email) {
+		tx.Rollback()
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"success": false,
+			"error":   "This invitation was sent to a different email",
+		})
+	}
+	member := &models.WorkspaceMember{WorkspaceId: invitation.WorkspaceId, UserId: user.Id}
+	if err := member.GetMember(tx); err == nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"message": "Already a member",
+			"success": false,
+			"error":   "You are already a member of this workspace",
+		})
+	}
+	member.Role = invitation.Role
+	if err := member.CreateMember(tx); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error joining workspace",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if err := invitation.DeleteInvitation(tx); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error joining workspace",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	recordAudit(c, member.UserId, models.AUDIT_MEMBER_ADD, models.AUDIT_TARGET_WORKSPACE, member.WorkspaceId,
+		nil, fiber.Map{"userId": member.UserId, "role": member.Role})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Joined workspace successfully",
+		"success": true,
+		"data":    member,
+	})
+}
+
+func UpdateWorkspaceMember(c *fiber.Ctx) error {
+	req := new(MemberRoleRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	if !models.IsValidWorkspaceRole(req.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid role",
+			"success": false,
+			"error":   "role must be one of: owner, editor, viewer",
+		})
+	}
+	workspaceId := c.Params("id")
+	tx := config.GetMySQLClient().Begin()
+	if err := requireWorkspaceRole(tx, workspaceId, c.Locals("userId").(string), models.WORKSPACE_ROLE_OWNER); err != nil {
+		tx.Rollback()
+		return workspaceError(c, err)
+	}
+	member := &models.WorkspaceMember{WorkspaceId: workspaceId, UserId: c.Params("userId")}
+	if err := member.GetMember(tx); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Member not found",
+			"success": false,
+			"error":   "Member not found",
+		})
+	}
+	if member.Role == models.WORKSPACE_ROLE_OWNER && req.Role != models.WORKSPACE_ROLE_OWNER {
+		if err := ensureAnotherOwner(tx, workspaceId); err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Cannot change role",
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+	}
+	previousRole := member.Role
+	if err := tx.Model(member).Update("role", req.Role).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error updating member",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	recordAudit(c, currentUserId(c), models.AUDIT_MEMBER_ROLE, models.AUDIT_TARGET_WORKSPACE, workspaceId,
+		fiber.Map{"userId": member.UserId, "role": previousRole}, fiber.Map{"userId": member.UserId, "role": req.Role})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Member updated successfully",
+		"success": true,
+		"data":    member,
+	})
+}
+
+// RemoveWorkspaceMember removes a member, owners can remove anyone and members
+// can remove themselves. Links created by the member stay in the workspace.
+func RemoveWorkspaceMember(c *fiber.Ctx) error {
+	workspaceId := c.Params("id")
+	userId := c.Locals("userId").(string)
+	targetId := c.Params("userId")
+	tx := config.GetMySQLClient().Begin()
+	minRole := models.WORKSPACE_ROLE_OWNER
+	if targetId == userId {
+		minRole = models.WORKSPACE_ROLE_VIEWER
+	}
+	if err := requireWorkspaceRole(tx, workspaceId, userId, minRole); err != nil {
+		tx.Rollback()
+		return workspaceError(c, err)
+	}
+	member := &models.WorkspaceMember{WorkspaceId: workspaceId, UserId: targetId}
+	if err := member.GetMember(tx); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Member not found",
+			"success": false,
+			"error":   "Member not found",
+		})
+	}
+	if member.Role == models.WORKSPACE_ROLE_OWNER {
+		if err := ensureAnotherOwner(tx, workspaceId); err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Cannot remove member",
+				"success": false,
+				"error":   err.Error(),
+			})
+		}
+	}
+	if err := member.DeleteMember(tx); err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Error removing member",
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	tx.Commit()
+	recordAudit(c, userId, models.AUDIT_MEMBER_REMOVE, models.AUDIT_TARGET_WORKSPACE, workspaceId,
+		fiber.Map{"userId": member.UserId, "role": member.Role}, nil)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Member removed successfully",
+		"success": true,
+	})
+}
+
+// ensureAnotherOwner prevents a workspace from being left without an owner
+func ensureAnotherOwner(tx *gorm.DB, workspaceId string) error {
+	owners, err := models.CountWorkspaceOwners(tx, workspaceId)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return errors.New("a workspace must keep at least one owner")
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Package shortcode generates the codes of short links. The strategy is picked
+// with SHORTCODE_STRATEGY: random (default), counter or time.
+package shortcode
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ydv-ankit/go-url-shortener/config"
+)
+
+const (
+	STRATEGY_RANDOM  = "random"
+	STRATEGY_COUNTER = "counter"
+	STRATEGY_TIME    = "time"
+)
+
+// ALPHABET is base62 in ascii order, so time ordered codes sort by creation time
+const ALPHABET = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Generator creates short codes of at least the given length
+type Generator interface {
+	Generate(length int) (string, error)
+}
+
+var generator Generator = RandomGenerator{}
+
+// Configure selects the generator named by SHORTCODE_STRATEGY
+func Configure() error {
+	switch strategy := os.Getenv("SHORTCODE_STRATEGY"); strategy {
+	case "", STRATEGY_RANDOM:
+		generator = RandomGenerator{}
+	case STRATEGY_COUNTER:
+		generator = NewCounterGenerator(os.Getenv("SHORTCODE_SALT"))
+	case STRATEGY_TIME:
+		generator = TimeGenerator{}
+	default:
+		return fmt.Errorf("unknown SHORTCODE_STRATEGY %q, use one of: random, counter, time", strategy)
+	}
+	return nil
+}
+
+// Default returns the configured generator
+func Default() Generator {
+	return generator
+}
+
+// randomString returns length characters drawn uniformly from alphabet
+func randomString(alphabet string, length int) (string, error) {
+	code := make([]byte, length)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// encode writes n in base len(alphabet), left padded to length
+func encode(alphabet string, n *big.Int, length int) string {
+	base := big.NewInt(int64(len(alphabet)))
+	value := new(big.Int).Set(n)
+	digit := new(big.Int)
+	code := []byte{}
+	for value.Sign() > 0 {
+		value.DivMod(value, base, digit)
+		code = append(code, alphabet[digit.Int64()])
+	}
+	for len(code) < length {
+		code = append(code, alphabet[0])
+	}
+	for i, j := 0, len(code)-1; i < j; i, j = i+1, j-1 {
+		code[i], code[j] = code[j], code[i]
+	}
+	return string(code)
+}
+
+// Fingerprint returns a hex HMAC-SHA256 of input keyed with key, the input
+// of Derive
+func Fingerprint(key string, input string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(input))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Derive returns the code of a fingerprint, the same for every call with the
+// same arguments. Each attempt gives another code for when earlier ones are taken.
+func Derive(key string, fingerprint string, attempt int, length int) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(fingerprint + ":" + strconv.Itoa(attempt)))
+	n := new(big.Int).SetBytes(mac.Sum(nil))
+	base := big.NewInt(int64(len(ALPHABET)))
+	n.Mod(n, new(big.Int).Exp(base, big.NewInt(int64(length)), nil))
+	return encode(ALPHABET, n, length)
+}
+
+// RandomGenerator draws every character from crypto/rand
+type RandomGenerator struct{}
+
+func (RandomGenerator) Generate(length int) (string, error) {
+	return randomString(ALPHABET, length)
+}
+
+// counterMultiplier is coprime with 62, so multiplying by it modulo 62^length
+// is a bijection on the codes of that length
+var counterMultiplier = big.NewInt(56800235633)
+
+// CounterGenerator encodes a counter shared through redis. Consecutive values
+// are scattered over the keyspace by a modular multiplication and a salted
+// alphabet, so codes never collide and are not guessable from each other.
+type CounterGenerator struct {
+	alphabet string
+}
+
+// NewCounterGenerator shuffles the alphabet deterministically with salt
+func NewCounterGenerator(salt string) CounterGenerator {
+	alphabet := []byte(ALPHABET)
+	if salt != "" {
+		seed := sha256.Sum256([]byte(salt))
+		for i := len(alphabet) - 1; i > 0; i-- {
+			seed = sha256.Sum256(seed[:])
+			j := int(binary.BigEndian.Uint64(seed[:8]) % uint64(i+1))
+			alphabet[i], alphabet[j] = alphabet[j], alphabet[i]
+		}
+	}
+	return CounterGenerator{alphabet: string(alphabet)}
+}
+
+func (g CounterGenerator) Generate(length int) (string, error) {
+	counter, err := config.GetRedisClient(0).Incr(config.RedisCtx, "shortcode:counter").Result()
+	if err != nil {
+		return "", errors.New("short code counter unavailable: " + err.Error())
+	}
+	n := big.NewInt(counter)
+	base := big.NewInt(int64(len(g.alphabet)))
+	// the counter outgrew the requested length
+	keyspace := new(big.Int).Exp(base, big.NewInt(int64(length)), nil)
+	for keyspace.Cmp(n) <= 0 {
+		length++
+		keyspace.Mul(keyspace, base)
+	}
+	scattered := new(big.Int).Mul(n, counterMultiplier)
+	scattered.Mod(scattered, keyspace)
+	return encode(g.alphabet, scattered, length), nil
+}
+
+// codeEpoch is the start of time ordered codes, seven characters of
+// milliseconds since then last until the 2130s
+var codeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	TIME_PART_LENGTH       = 7
+	MIN_RANDOM_PART_LENGTH = 2
+)
+
+// TimeGenerator prefixes codes with the creation time in milliseconds, followed
+// by random characters that tell apart codes created in the same millisecond.
+// Codes sort by creation time.
+type TimeGenerator struct{}
+
+func (TimeGenerator) Generate(length int) (string, error) {
+	millis := big.NewInt(time.Since(codeEpoch).Milliseconds())
+	suffix, err := randomString(ALPHABET, max(length-TIME_PART_LENGTH, MIN_RANDOM_PART_LENGTH))
+	if err != nil {
+		return "", err
+	}
+	return encode(ALPHABET, millis, TIME_PART_LENGTH) + suffix, nil
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const DESTINATION_MAX_LENGTH = 2048
+
+// error codes returned by NormalizeDestination
+const (
+	DEST_EMPTY              = "destination_empty"
+	DEST_TOO_LONG           = "destination_too_long"
+	DEST_MALFORMED          = "destination_malformed"
+	DEST_RELATIVE           = "destination_relative"
+	DEST_SCHEME_NOT_ALLOWED = "destination_scheme_not_allowed"
+	DEST_CREDENTIALS        = "destination_credentials_not_allowed"
+	DEST_INVALID_HOST       = "destination_invalid_host"
+	DEST_INVALID_PORT       = "destination_invalid_port"
+	DEST_SELF_REFERENCE     = "destination_self_reference"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// DestinationError describes why a destination url was rejected
+type DestinationError struct {
+	Code    string
+	Message string
+}
+
+func (e *DestinationError) Error() string {
+	return e.Message
+}
+
+func destinationError(code string, message string) *DestinationError {
+	return &DestinationError{Code: code, Message: message}
+}
+
+// allowedSchemes returns the schemes listed in DESTINATION_SCHEMES, http and https by default
+func allowedSchemes() []string {
+	schemes := []string{}
+	for _, scheme := range strings.Split(os.Getenv("DESTINATION_SCHEMES"), ",") {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			schemes = append(schemes, scheme)
+		}
+	}
+	if len(schemes) == 0 {
+		return []string{"http", "https"}
+	}
+	return schemes
+}
+
+// ServiceHostname returns the lowercased hostname of DOMAIN without its port
+func ServiceHostname() string {
+	domain := strings.ToLower(strings.TrimSpace(os.Getenv("DOMAIN")))
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		return host
+	}
+	return domain
+}
+
+// NormalizeDestination validates a long url and returns its canonical form:
+// lowercase scheme and punycode host, no default port and no trailing dot.
+// Rejected urls return a *DestinationError with a stable error code. Hosts
+// servedHost reports as served by this service, besides DOMAIN, are rejected
+// like DOMAIN, servedHost may be nil.
+func NormalizeDestination(raw string, servedHost func(host string) bool) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", destinationError(DEST_EMPTY, "long url is required")
+	}
+	maxLength := GetEnvInt("DESTINATION_MAX_LENGTH", DESTINATION_MAX_LENGTH)
+	if len(raw) > maxLength {
+		return "", destinationError(DEST_TOO_LONG, "long url must be at most "+strconv.Itoa(maxLength)+" characters")
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", destinationError(DEST_MALFORMED, "long url is not a valid url")
+	}
+	if parsed.Scheme == "" {
+		return "", destinationError(DEST_RELATIVE, "long url must be absolute, including the scheme (e.g. https://)")
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	allowed := false
+	for _, s := range allowedSchemes() {
+		if s == scheme {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", destinationError(DEST_SCHEME_NOT_ALLOWED, "scheme '"+scheme+"' is not allowed, use one of: "+strings.Join(allowedSchemes(), ", "))
+	}
+	if parsed.Opaque != "" || parsed.Host == "" {
+		return "", destinationError(DEST_INVALID_HOST, "long url must contain a host")
+	}
+	if parsed.User != nil {
+		return "", destinationError(DEST_CREDENTIALS, "long url must not contain credentials")
+	}
+	host, err := normalizeHost(parsed.Hostname())
+	if err != nil {
+		return "", err
+	}
+	port := parsed.Port()
+	if port != "" {
+		number, err := strconv.Atoi(port)
+		if err != nil || number < 1 || number > 65535 {
+			return "", destinationError(DEST_INVALID_PORT, "long url has an invalid port")
+		}
+		if defaultPorts[scheme] == port {
+			port = ""
+		}
+	}
+	serviceHost := ServiceHostname()
+	if serviceHost != "" && host == serviceHost || servedHost != nil && servedHost(host) {
+		return "", destinationError(DEST_SELF_REFERENCE, "long url must not point to this url shortener")
+	}
+	parsed.Scheme = scheme
+	parsed.Host = host
+	if strings.Contains(host, ":") {
+		// ipv6 literal
+		parsed.Host = "[" + host + "]"
+	}
+	if port != "" {
+		parsed.Host += ":" + port
+	}
+	return parsed.String(), nil
+}
+
+// normalizeHost lowercases the host, converts IDNs to punycode and checks label syntax
+func normalizeHost(host string) (string, error) {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" {
+		return "", destinationError(DEST_INVALID_HOST, "long url must contain a host")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", destinationError(DEST_INVALID_HOST, "long url has an invalid host")
+	}
+	ascii = strings.ToLower(ascii)
+	if len(ascii) > 253 {
+		return "", destinationError(DEST_INVALID_HOST, "long url has an invalid host")
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", destinationError(DEST_INVALID_HOST, "long url has an invalid host")
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return "", destinationError(DEST_INVALID_HOST, "long url has an invalid host")
+			}
+		}
+	}
+	return ascii, nil
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeDestinationSelfReference(t *testing.T) {
+	t.Setenv("DOMAIN", "zip.link:8080")
+	customDomains := map[string]bool{"go.example.com": true}
+	servedHost := func(host string) bool { return customDomains[host] }
+	tests := []struct {
+		long string
+		want string
+		code string
+	}{
+		{"https://example.com/a", "https://example.com/a", ""},
+		{"https://go.example.org/a", "https://go.example.org/a", ""},
+		{"https://zip.link/abc", "", DEST_SELF_REFERENCE},
+		{"https://go.example.com/abc", "", DEST_SELF_REFERENCE},
+		{"HTTPS://Go.Example.COM./abc", "", DEST_SELF_REFERENCE},
+		{"http://go.example.com:8080/abc", "", DEST_SELF_REFERENCE},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeDestination(tt.long, servedHost)
+		code := ""
+		var destErr *DestinationError
+		if errors.As(err, &destErr) {
+			code = destErr.Code
+		} else if err != nil {
+			t.Fatalf("NormalizeDestination(%q) returned %v", tt.long, err)
+		}
+		if got != tt.want || code != tt.code {
+			t.Errorf("NormalizeDestination(%q) = %q, %q, want %q, %q", tt.long, got, code, tt.want, tt.code)
+		}
+	}
+	if _, err := NormalizeDestination("https://go.example.com/abc", nil); err != nil {
+		t.Errorf("NormalizeDestination without servedHost = %v, want nil", err)
+	}
+}
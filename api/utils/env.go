@@ -3,6 +3,7 @@ package utils
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -28,3 +29,22 @@ func EnvParser(filepath ...string) {
 	}
 	Log("env setup complete")
 }
+
+// GetEnvInt returns the integer value of an env variable or fallback when unset or invalid
+func GetEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// GetEnvBool returns true for "true" or "1", false for "false" or "0" and
+// fallback otherwise
+func GetEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
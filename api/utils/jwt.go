@@ -1,38 +1,276 @@
 package utils
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"math/big"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateToken(userId string) (string, error) {
-	if os.Getenv("JWT_SECRET") == "" {
-		return "", errors.New("jwt secret is not set")
+// signingKey is a key used to sign or verify tokens, private is nil for
+// verification-only keys
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+type keySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+	// legacy HS256 secret, accepted for tokens without a kid
+	secret []byte
+}
+
+var (
+	jwtKeys     *keySet
+	jwtKeysErr  error
+	jwtKeysOnce sync.Once
+)
+
+// LoadJWTKeys reads the signing keys from the environment once and reports configuration errors.
+//
+// JWT_SIGNING_KEYS lists private keys as comma separated kid:path pairs of PEM
+// files (RSA or Ed25519) and JWT_ACTIVE_KID picks the one used for signing.
+// JWT_VERIFY_KEYS lists public keys that are only accepted for verification.
+// Without signing keys tokens are signed with HS256 using JWT_SECRET.
+func LoadJWTKeys() error {
+	jwtKeysOnce.Do(func() {
+		jwtKeys, jwtKeysErr = loadKeySet()
+	})
+	return jwtKeysErr
+}
+
+func loadKeySet() (*keySet, error) {
+	set := &keySet{
+		keys:   map[string]*signingKey{},
+		secret: []byte(os.Getenv("JWT_SECRET")),
+	}
+	signing, err := parseKeyList(os.Getenv("JWT_SIGNING_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+	for kid, path := range signing {
+		key, err := loadPrivateKey(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		set.keys[kid] = key
+	}
+	verify, err := parseKeyList(os.Getenv("JWT_VERIFY_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+	for kid, path := range verify {
+		if _, ok := set.keys[kid]; ok {
+			return nil, fmt.Errorf("duplicate jwt key id %q", kid)
+		}
+		key, err := loadPublicKey(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		set.keys[kid] = key
+	}
+	if len(signing) == 0 {
+		if len(set.secret) == 0 {
+			return nil, errors.New("jwt secret is not set")
+		}
+		return set, nil
+	}
+	activeKid := os.Getenv("JWT_ACTIVE_KID")
+	active, ok := set.keys[activeKid]
+	if !ok || active.private == nil {
+		return nil, fmt.Errorf("JWT_ACTIVE_KID %q must name one of JWT_SIGNING_KEYS", activeKid)
+	}
+	set.active = active
+	return set, nil
+}
+
+// parseKeyList parses "kid:path,kid:path" into a map
+func parseKeyList(value string) (map[string]string, error) {
+	keys := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(entry, ":")
+		if !ok || kid == "" || path == "" {
+			return nil, fmt.Errorf("invalid jwt key entry %q, expected kid:path", entry)
+		}
+		keys[kid] = path
+	}
+	return keys, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	return block, nil
+}
+
+func loadPrivateKey(kid string, path string) (*signingKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var parsed any
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt key %s: %w", kid, err)
+	}
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: key, public: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: key, public: key.Public()}, nil
+	}
+	return nil, fmt.Errorf("jwt key %s: unsupported key type %T", kid, parsed)
+}
+
+func loadPublicKey(kid string, path string) (*signingKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var parsed any
+	if block.Type == "RSA PUBLIC KEY" {
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt key %s: %w", kid, err)
+	}
+	switch key := parsed.(type) {
+	case *rsa.PublicKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, public: key}, nil
+	case ed25519.PublicKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, public: key}, nil
+	}
+	return nil, fmt.Errorf("jwt key %s: unsupported key type %T", kid, parsed)
+}
+
+// GenerateToken signs a session token for the user. version must match the
+// user's current token version for the token to stay valid.
+func GenerateToken(userId string, version int) (string, error) {
+	if err := LoadJWTKeys(); err != nil {
+		return "", err
 	}
 	claims := jwt.MapClaims{
 		"userId": userId,
+		"ver":    version,
 		"exp":    time.Now().Add(time.Hour * 24).Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if jwtKeys.active == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString(jwtKeys.secret)
+	}
+	token := jwt.NewWithClaims(jwtKeys.active.method, claims)
+	token.Header["kid"] = jwtKeys.active.kid
+	return token.SignedString(jwtKeys.active.private)
 }
 
-func VerifyToken(tokenString string) (string, error) {
-	if os.Getenv("JWT_SECRET") == "" {
-		return "", errors.New("jwt secret is not set")
+// VerifyToken returns the user id and token version of a valid token
+func VerifyToken(tokenString string) (string, int, error) {
+	if err := LoadJWTKeys(); err != nil {
+		return "", 0, err
+	}
+	methods := []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+	if len(jwtKeys.secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
 	}
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			// tokens without a kid can only be legacy HS256 tokens
+			if token.Method.Alg() != jwt.SigningMethodHS256.Alg() || len(jwtKeys.secret) == 0 {
+				return nil, errors.New("unexpected signing method")
+			}
+			return jwtKeys.secret, nil
+		}
+		key, ok := jwtKeys.keys[kid]
+		if !ok {
+			return nil, errors.New("unknown key id")
+		}
+		// the algorithm is pinned to the key, never taken from the token
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.public, nil
+	}, jwt.WithValidMethods(methods), jwt.WithExpirationRequired())
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok || !token.Valid {
-		return "", errors.New("invalid token")
+		return "", 0, errors.New("invalid token")
+	}
+	userId, ok := claims["userId"].(string)
+	if !ok {
+		return "", 0, errors.New("invalid token")
+	}
+	// tokens issued before versioning carry no version and count as 0
+	version, _ := claims["ver"].(float64)
+	return userId, int(version), nil
+}
+
+// JWKS returns the public verification keys as a JSON Web Key Set
+func JWKS() map[string]interface{} {
+	keys := []map[string]string{}
+	if err := LoadJWTKeys(); err != nil {
+		return map[string]interface{}{"keys": keys}
+	}
+	kids := make([]string, 0, len(jwtKeys.keys))
+	for kid := range jwtKeys.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	for _, kid := range kids {
+		key := jwtKeys.keys[kid]
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"alg": key.method.Alg(),
+				"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"kid": kid,
+				"use": "sig",
+				"alg": key.method.Alg(),
+				"x":   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
 	}
-	return claims["userId"].(string), nil
+	return map[string]interface{}{"keys": keys}
 }
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"runtime"
@@ -31,3 +32,12 @@ func Log(s string) {
 		fmt.Printf("[%s] -> %s", time.DateTime, s)
 	}
 }
+
+// LogSecurityEvent writes a structured security event to the log
+func LogSecurityEvent(event string, details map[string]string) {
+	payload, err := json.Marshal(details)
+	if err != nil {
+		payload = []byte("{}")
+	}
+	fmt.Printf("[%s] -> security event: %s %s\n", time.Now().Format(time.DateTime), event, payload)
+}
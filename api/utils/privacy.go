@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+)
+
+// ip privacy modes selected with IP_PRIVACY_MODE
+const (
+	IP_PRIVACY_RAW      = "raw"
+	IP_PRIVACY_TRUNCATE = "truncate"
+	IP_PRIVACY_HASH     = "hash"
+)
+
+// CheckIPPrivacyConfig reports an invalid IP_PRIVACY_MODE or a hash mode without IP_HASH_KEY
+func CheckIPPrivacyConfig() error {
+	switch ipPrivacyMode() {
+	case IP_PRIVACY_RAW, IP_PRIVACY_TRUNCATE:
+		return nil
+	case IP_PRIVACY_HASH:
+		if os.Getenv("IP_HASH_KEY") == "" {
+			return errors.New("IP_HASH_KEY is required when IP_PRIVACY_MODE is hash")
+		}
+		return nil
+	}
+	return errors.New("IP_PRIVACY_MODE must be one of: raw, truncate, hash")
+}
+
+func ipPrivacyMode() string {
+	if mode := os.Getenv("IP_PRIVACY_MODE"); mode != "" {
+		return mode
+	}
+	return IP_PRIVACY_RAW
+}
+
+// PrivacyIP returns the form of a visitor ip that may be stored: the ip itself,
+// its network (/24 for IPv4, /48 for IPv6) or a keyed hash that still tells
+// repeat visitors apart without revealing the address
+func PrivacyIP(ip string) string {
+	switch ipPrivacyMode() {
+	case IP_PRIVACY_TRUNCATE:
+		return truncateIP(ip)
+	case IP_PRIVACY_HASH:
+		mac := hmac.New(sha256.New, []byte(os.Getenv("IP_HASH_KEY")))
+		mac.Write([]byte(ip))
+		return hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+	return ip
+}
+
+func truncateIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
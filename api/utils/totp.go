@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"image/png"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	RECOVERY_CODE_COUNT  = 10
+	RECOVERY_CODE_LENGTH = 10
+	TOTP_QR_SIZE         = 256
+	TOTP_PERIOD          = 30
+)
+
+// unambiguous characters for recovery codes (no 0/o, 1/l/i)
+const recoveryCodeChars = "23456789abcdefghjkmnpqrstuvwxyz"
+
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	if domain := os.Getenv("DOMAIN"); domain != "" {
+		return domain
+	}
+	return "ziplink"
+}
+
+// GenerateTotpKey creates a new RFC 6238 secret for the given account
+func GenerateTotpKey(accountName string) (*otp.Key, error) {
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer(),
+		AccountName: accountName,
+		Period:      TOTP_PERIOD,
+	})
+}
+
+// TotpQRCode renders the provisioning uri of a key as a base64 png data uri
+func TotpQRCode(key *otp.Key) (string, error) {
+	img, err := key.Image(TOTP_QR_SIZE, TOTP_QR_SIZE)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// ValidateTotp checks a code against the secret, allowing one period of clock skew
+func ValidateTotp(code string, secret string) bool {
+	valid, err := totp.ValidateCustom(strings.TrimSpace(code), secret, time.Now().UTC(), totp.ValidateOpts{
+		Period:    TOTP_PERIOD,
+		Skew:      1,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	return err == nil && valid
+}
+
+// GenerateRecoveryCodes returns plain text one-time recovery codes formatted as xxxxx-xxxxx
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RECOVERY_CODE_COUNT)
+	for i := range codes {
+		b := make([]byte, RECOVERY_CODE_LENGTH)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		for j := range b {
+			b[j] = recoveryCodeChars[int(b[j])%len(recoveryCodeChars)]
+		}
+		half := RECOVERY_CODE_LENGTH / 2
+		codes[i] = string(b[:half]) + "-" + string(b[half:])
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode normalizes a recovery code and returns its sha256 hex digest
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+)
+
+// used when BANNED_WORDS_FILE is not set
+var defaultBannedWords = []string{
+	"fuck", "shit", "cunt", "bitch", "whore", "slut", "dick", "cock", "pussy",
+	"penis", "vagina", "porn", "nazi", "rape", "nigg", "fag", "retard", "twat",
+	"wank", "bastard", "asshole", "kike", "spic", "chink",
+}
+
+// leetspeak and look-alike characters folded to the letter they imitate. l and
+// 1 both fold to i so either spelling matches.
+var foldChars = map[rune]rune{
+	'0': 'o',
+	'1': 'i', 'l': 'i', '!': 'i', '|': 'i',
+	'3': 'e',
+	'4': 'a', '@': 'a',
+	'5': 's', '$': 's',
+	'6': 'g', '9': 'g',
+	'7': 't', '+': 't',
+	'8': 'b',
+}
+
+var (
+	bannedWords     []string
+	bannedWordsOnce sync.Once
+)
+
+// FoldForFilter lowercases s, maps leetspeak characters to letters and drops
+// separators, so "Sh-1T" and "shit" fold to the same string
+func FoldForFilter(s string) string {
+	var folded strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if r == '-' || r == '_' || r == '.' {
+			continue
+		}
+		if mapped, ok := foldChars[r]; ok {
+			r = mapped
+		}
+		folded.WriteRune(r)
+	}
+	return folded.String()
+}
+
+// loadBannedWords reads BANNED_WORDS_FILE, one word per line with # comments,
+// and falls back to the built-in list
+func loadBannedWords() {
+	words := defaultBannedWords
+	if path := os.Getenv("BANNED_WORDS_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			Log("error reading BANNED_WORDS_FILE, using the built-in list: " + err.Error())
+		} else {
+			defer file.Close()
+			words = []string{}
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				if word := strings.TrimSpace(scanner.Text()); word != "" && !strings.HasPrefix(word, "#") {
+					words = append(words, word)
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				Log("error reading BANNED_WORDS_FILE: " + err.Error())
+			}
+		}
+	}
+	for _, word := range words {
+		if folded := FoldForFilter(word); folded != "" {
+			bannedWords = append(bannedWords, folded)
+		}
+	}
+}
+
+// ContainsBannedWord reports whether s contains a banned word after folding
+func ContainsBannedWord(s string) bool {
+	bannedWordsOnce.Do(loadBannedWords)
+	folded := FoldForFilter(s)
+	for _, word := range bannedWords {
+		if strings.Contains(folded, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,9 @@
-import { useState, type FormEvent } from "react";
+import { useEffect, useState, type FormEvent } from "react";
 import { api, ApiError } from "../services/api";
-import type { Url } from "../types";
+import type { Availability, Url } from "../types";
+
+// wait for a pause in typing before checking an alias
+const AVAILABILITY_DEBOUNCE_MS = 400;
 
 interface CreateUrlModalProps {
 	isOpen: boolean;
@@ -18,6 +21,27 @@ export default function CreateUrlModal({
 	const [expiry, setExpiry] = useState("");
 	const [loading, setLoading] = useState(false);
 	const [error, setError] = useState<string | null>(null);
+	const [availability, setAvailability] = useState<Availability | null>(null);
+
+	useEffect(() => {
+		setAvailability(null);
+		if (customShort.length < 3) return;
+		let cancelled = false;
+		const timer = setTimeout(async () => {
+			try {
+				const response = await api.checkAvailability(customShort);
+				if (!cancelled && response.data) {
+					setAvailability(response.data);
+				}
+			} catch {
+				// the create request reports the problem instead
+			}
+		}, AVAILABILITY_DEBOUNCE_MS);
+		return () => {
+			cancelled = true;
+			clearTimeout(timer);
+		};
+	}, [customShort]);
 
 	const validateCustomShort = (value: string): string | null => {
 		if (value === "") return null; // Empty is allowed
@@ -28,8 +52,8 @@ export default function CreateUrlModal({
 		if (value.length > 20) {
 			return "Custom short code must be at most 20 characters";
 		}
-		if (!/^[a-zA-Z0-9]+$/.test(value)) {
-			return "Custom short code must contain only letters and numbers";
+		if (!/^[a-zA-Z0-9]+([-_][a-zA-Z0-9]+)*$/.test(value)) {
+			return "Custom short code must contain only letters, numbers, hyphens and underscores, with hyphens and underscores only between letters or numbers";
 		}
 		return null;
 	};
@@ -167,12 +191,35 @@ export default function CreateUrlModal({
 							}}
 							className="w-full px-4 py-3 border border-gray-300 rounded-lg focus:ring-2 focus:ring-indigo-500 focus:border-transparent outline-none transition"
 							placeholder="myCustomLink"
-							pattern="[a-zA-Z0-9]+"
+							pattern="[a-zA-Z0-9]+([\-_][a-zA-Z0-9]+)*"
 							maxLength={20}
 						/>
 						<p className="mt-1 text-xs text-gray-500">
-							3-20 characters, letters and numbers only
+							3-20 characters, letters, numbers, hyphens and underscores
 						</p>
+						{availability?.available && (
+							<p className="mt-1 text-xs text-green-600">Available</p>
+						)}
+						{availability && !availability.available && (
+							<div className="mt-1 text-xs">
+								<p className="text-red-600">{availability.error}</p>
+								{availability.suggestions.length > 0 && (
+									<div className="mt-2 flex flex-wrap items-center gap-2">
+										<span className="text-gray-500">Try:</span>
+										{availability.suggestions.map((suggestion) => (
+											<button
+												key={suggestion}
+												type="button"
+												onClick={() => setCustomShort(suggestion)}
+												className="px-2 py-1 rounded-full bg-indigo-50 text-indigo-700 hover:bg-indigo-100 font-mono cursor-pointer transition"
+											>
+												{suggestion}
+											</button>
+										))}
+									</div>
+								)}
+							</div>
+						)}
 					</div>
 
 					<div>
@@ -6,6 +6,7 @@ import type {
 	RegisterRequest,
 	ShortenUrlRequest,
 	DeleteUrlRequest,
+	Availability,
 } from "../types";
 
 const API_BASE_URL = import.meta.env.VITE_API_URL || "http://localhost:3000";
@@ -78,6 +79,45 @@ async function fetchApi<T>(
 	}
 }
 
+const CSRF_HEADER = "X-CSRF-Token";
+let csrfToken: string | null = null;
+
+async function getCsrfToken(): Promise<string> {
+	if (!csrfToken) {
+		const response = await fetchApi<{ token: string }>("/api/v1/csrf", {
+			method: "GET",
+			credentials: "include",
+		});
+		csrfToken = response.data?.token || null;
+	}
+	return csrfToken || "";
+}
+
+// fetchWithCsrf sends the CSRF token with cookie authenticated mutations and
+// retries once with a fresh token when the session rotated it
+async function fetchWithCsrf<T>(
+	endpoint: string,
+	options: RequestInit = {}
+): Promise<ApiResponse<T>> {
+	const send = async () =>
+		fetchApi<T>(endpoint, {
+			...options,
+			headers: {
+				"Content-Type": "application/json",
+				[CSRF_HEADER]: await getCsrfToken(),
+			},
+		});
+	try {
+		return await send();
+	} catch (error) {
+		if (error instanceof ApiError && error.status === 403 && error.message === "Invalid CSRF token") {
+			csrfToken = null;
+			return send();
+		}
+		throw error;
+	}
+}
+
 export const api = {
 	// Auth endpoints
 	async register(data: RegisterRequest): Promise<ApiResponse<User>> {
@@ -89,6 +129,8 @@ export const api = {
 	},
 
 	async login(data: LoginRequest): Promise<ApiResponse<User>> {
+		// a new session gets a new csrf token
+		csrfToken = null;
 		const response = await fetchApi<{ userId: string; name: string; email: string }>(
 			"/api/v1/login",
 			{
@@ -112,6 +154,7 @@ export const api = {
 	},
 
 	async logout(): Promise<ApiResponse> {
+		csrfToken = null;
 		return fetchApi("/api/v1/logout", {
 			method: "POST",
 			credentials: "include",
@@ -127,15 +170,25 @@ export const api = {
 	},
 
 	async shortenUrl(data: ShortenUrlRequest): Promise<ApiResponse<Url>> {
-		return fetchApi<Url>("/api/v1/shorten", {
+		return fetchWithCsrf<Url>("/api/v1/shorten", {
 			method: "POST",
 			body: JSON.stringify(data),
 			credentials: "include",
 		});
 	},
 
+	async checkAvailability(alias: string): Promise<ApiResponse<Availability>> {
+		return fetchApi<Availability>(
+			`/api/v1/shorten/availability?alias=${encodeURIComponent(alias)}`,
+			{
+				method: "GET",
+				credentials: "include",
+			}
+		);
+	},
+
 	async deleteUrl(data: DeleteUrlRequest): Promise<ApiResponse> {
-		return fetchApi("/api/v1/delete", {
+		return fetchWithCsrf("/api/v1/delete", {
 			method: "DELETE",
 			body: JSON.stringify(data),
 			credentials: "include",
@@ -41,6 +41,14 @@ export interface ShortenUrlRequest {
 	expiry?: string;
 }
 
+export interface Availability {
+	alias: string;
+	available: boolean;
+	reason?: "invalid" | "reserved" | "banned" | "taken";
+	error?: string;
+	suggestions: string[];
+}
+
 export interface DeleteUrlRequest {
 	id: string;
 }